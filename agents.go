@@ -0,0 +1,173 @@
+package main
+
+//признак агента позиции (тег 1222) и данные агента: операция платёжного агента (тег 1044), телефоны платёжного агента,
+//оператора по приёму платежей и оператора перевода (теги 1073, 1074, 1075), данные оператора перевода (теги 1005, 1016, 1026)
+//и поставщика (теги 1171, 1225, 1226)
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// значения признака агента в формате драйвера атол в порядке битов тега 1222
+var agentTypesOfBits = []string{"bankPayingAgent", "bankPayingSubagent", "payingAgent", "payingSubagent", "attorney", "commissionAgent", "another"}
+
+// признак агента по текстовому значению из данных ОФД (ё заменена на е, без точек)
+var agentTypesDict = map[string]string{
+	"банковский платежный агент": "bankPayingAgent", "бпа": "bankPayingAgent",
+	"банковский платежный субагент": "bankPayingSubagent", "бпса": "bankPayingSubagent",
+	"платежный агент": "payingAgent", "па": "payingAgent",
+	"платежный субагент": "payingSubagent", "пса": "payingSubagent",
+	"поверенный": "attorney", "комиссионер": "commissionAgent",
+	"агент": "another", "другой тип агента": "another", "иной агент": "another", "иной": "another", "другой": "another",
+}
+
+var regexpINN = regexp.MustCompile(`^(\d{10}|\d{12})$`)
+
+// getAgentsFromStr - признаки агента по значению из данных ОФД: название ("Комиссионер", "Платежный агент"),
+// значение тега 1222 (32, 64 ...) или значения драйвера атол через запятую. Пустой список - позиция не агентская
+func getAgentsFromStr(prizagenta string) ([]string, error) {
+	prizClean := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(prizagenta)), "ё", "е")
+	prizClean = strings.ReplaceAll(prizClean, ".", "")
+	if prizClean == "" || prizClean == "-" || prizClean == "0" || strings.HasPrefix(prizClean, "нет") || strings.HasPrefix(prizClean, "не ") {
+		return nil, nil
+	}
+	if bits, err := strconv.Atoi(prizClean); err == nil {
+		var res []string
+		for i, agent := range agentTypesOfBits {
+			if bits&(1<<i) != 0 {
+				res = append(res, agent)
+			}
+		}
+		if len(res) == 0 || bits >= 1<<len(agentTypesOfBits) {
+			return nil, fmt.Errorf("неверное значение признака агента %v", prizagenta)
+		}
+		return res, nil
+	}
+	var res []string
+	for _, val := range strings.FieldsFunc(prizClean, func(r rune) bool { return r == ',' || r == ';' }) {
+		val = strings.TrimSpace(val)
+		agent, ok := agentTypesDict[val]
+		if !ok {
+			for _, agentOfBit := range agentTypesOfBits {
+				if strings.EqualFold(agentOfBit, val) {
+					agent, ok = agentOfBit, true
+				}
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("не удалось определить признак агента \"%v\"", prizagenta)
+		}
+		res = append(res, agent)
+	}
+	return res, nil
+}
+
+// getNormalizedPhone - телефон в формате +7XXXXXXXXXX (или + и до 19 цифр для иностранных номеров).
+// Российские номера 8XXXXXXXXXX, 7XXXXXXXXXX и 9XXXXXXXXX приводятся к +7
+func getNormalizedPhone(phone string) (string, error) {
+	digits := ""
+	for _, r := range strings.TrimSpace(phone) {
+		switch {
+		case r >= '0' && r <= '9':
+			digits += string(r)
+		case r == ' ', r == '-', r == '(', r == ')', r == '+' && digits == "":
+		default:
+			return "", fmt.Errorf("неверный формат телефона \"%v\"", phone)
+		}
+	}
+	switch {
+	case len(digits) == 11 && (digits[0] == '8' || digits[0] == '7'):
+		return "+7" + digits[1:], nil
+	case len(digits) == 10 && digits[0] == '9':
+		return "+7" + digits, nil
+	case strings.HasPrefix(strings.TrimSpace(phone), "+") && len(digits) >= 11 && len(digits) <= 19:
+		return "+" + digits, nil
+	}
+	return "", fmt.Errorf("неверный формат телефона \"%v\"", phone)
+}
+
+// getPhonesFromStr - телефоны из ячейки данных ОФД (через запятую или точку с запятой). Неверные телефоны
+// не попадают в результат и перечисляются в ошибке
+func getPhonesFromStr(phones string) ([]string, error) {
+	var res []string
+	var errs []string
+	for _, phone := range strings.FieldsFunc(phones, func(r rune) bool { return r == ',' || r == ';' }) {
+		if strings.TrimSpace(phone) == "" {
+			continue
+		}
+		normPhone, err := getNormalizedPhone(phone)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		res = append(res, normPhone)
+	}
+	if len(errs) > 0 {
+		return res, errors.New(strings.Join(errs, "; "))
+	}
+	return res, nil
+}
+
+// getAgentOfPos - признак агента и данные агента и поставщика позиции. Позиция агентская, если заполнен признак
+// агента или (как раньше) только ИНН поставщика - тогда признак "комиссионер". Для агентской позиции ИНН поставщика
+// обязателен, ошибка в нём - ошибка чека. Неверные необязательные значения (нераспознанный признак агента - позиция
+// не агентская, как раньше, телефоны, ИНН оператора перевода) не передаются и записываются в отчёт
+func getAgentOfPos(pos map[string]string, strInfoAboutCheck string) (*TAgentInfo, *TSupplierInfo, error) {
+	reportOfPos := func(descr string, err error) {
+		logsmap[LOGREPORT].Printf("чек %v: для позиции \"%v\" не передаётся %v: %v", strInfoAboutCheck, pos[COLNAME], descr, err)
+	}
+	agents, err := getAgentsFromStr(pos[COLPRIZAGENTA])
+	if err != nil {
+		reportOfPos("признак агента (тег 1222)", err)
+		return nil, nil, nil
+	}
+	innOfSupplier := strings.TrimSpace(pos[COLINNOFSUPPLIER])
+	if len(agents) == 0 {
+		if pos[COLPRIZAGENTA] != "" || innOfSupplier == "" {
+			return nil, nil, nil
+		}
+		agents = []string{"commissionAgent"}
+	}
+	if innOfSupplier == "" {
+		return nil, nil, errors.New("для агентской позиции не указан ИНН поставщика (тег 1226)")
+	}
+	if !regexpINN.MatchString(innOfSupplier) {
+		return nil, nil, fmt.Errorf("неверный ИНН поставщика (тег 1226) \"%v\"", innOfSupplier)
+	}
+	agentInfo := &TAgentInfo{Agents: agents}
+	supplierInfo := &TSupplierInfo{Vatin: innOfSupplier, Name: strings.TrimSpace(pos[COLNAMEOFSUPPLIER])}
+	if supplierInfo.Phones, err = getPhonesFromStr(pos[COLTELOFSUPPLIER]); err != nil {
+		reportOfPos("телефон поставщика (тег 1171)", err)
+	}
+	operation := strings.TrimSpace(pos[COLOPERAGENTA])
+	phonesOfAgent, err := getPhonesFromStr(pos[COLTELAGENTA])
+	if err != nil {
+		reportOfPos("телефон платёжного агента (тег 1073)", err)
+	}
+	if operation != "" || len(phonesOfAgent) > 0 {
+		agentInfo.PayingAgent = &TPayingAgent{Operation: operation, Phones: phonesOfAgent}
+	}
+	phonesOfPayOper, err := getPhonesFromStr(pos[COLTELOFPAYOPER])
+	if err != nil {
+		reportOfPos("телефон оператора по приёму платежей (тег 1074)", err)
+	}
+	if len(phonesOfPayOper) > 0 {
+		agentInfo.ReceivePaymentsOperator = &TReceivePaymentsOperator{Phones: phonesOfPayOper}
+	}
+	transferOper := TMoneyTransferOperator{Name: strings.TrimSpace(pos[COLNAMEOFTRANSOPER]),
+		Address: strings.TrimSpace(pos[COLADDROFTRANSOPER]), Vatin: strings.TrimSpace(pos[COLINNOFTRANSOPER])}
+	if transferOper.Phones, err = getPhonesFromStr(pos[COLTELOFTRANSOPER]); err != nil {
+		reportOfPos("телефон оператора перевода (тег 1075)", err)
+	}
+	if transferOper.Vatin != "" && !regexpINN.MatchString(transferOper.Vatin) {
+		reportOfPos("ИНН оператора перевода (тег 1016)", fmt.Errorf("неверный ИНН \"%v\"", transferOper.Vatin))
+		transferOper.Vatin = ""
+	}
+	if transferOper.Name != "" || transferOper.Address != "" || transferOper.Vatin != "" || len(transferOper.Phones) > 0 {
+		agentInfo.MoneyTransferOperator = &transferOper
+	}
+	return agentInfo, supplierInfo, nil
+}
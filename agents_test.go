@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetNormalizedPhone(t *testing.T) {
+	tests := []struct {
+		phone   string
+		want    string
+		wantErr bool
+	}{
+		{"+7 (912) 345-67-89", "+79123456789", false},
+		{"8 912 345 67 89", "+79123456789", false},
+		{"79123456789", "+79123456789", false},
+		{"9123456789", "+79123456789", false},
+		{"+375 29 123-45-67", "+375291234567", false},
+		{"12345", "", true},
+		{"8912345678a", "", true},
+		{"+7 912 +345", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := getNormalizedPhone(tt.phone)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("getNormalizedPhone(\"%v\") = \"%v\", %v, ожидалось \"%v\", ошибка %v", tt.phone, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestGetPhonesFromStr(t *testing.T) {
+	got, err := getPhonesFromStr("8 912 345 67 89; 12ab, +7 900 111-22-33")
+	want := []string{"+79123456789", "+79001112233"}
+	if !reflect.DeepEqual(got, want) || err == nil {
+		t.Errorf("телефоны %v (ошибка %v), ожидались %v и ошибка неверного телефона", got, err, want)
+	}
+}
+
+func TestGetAgentsFromStr(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"нет", nil, false},
+		{"0", nil, false},
+		{"Комиссионер", []string{"commissionAgent"}, false},
+		{"Платёжный агент", []string{"payingAgent"}, false},
+		{"Банковский платежный субагент", []string{"bankPayingSubagent"}, false},
+		{"П.А.", []string{"payingAgent"}, false},
+		{"поверенный; другой тип агента", []string{"attorney", "another"}, false},
+		{"payingSubagent", []string{"payingSubagent"}, false},
+		{"32", []string{"commissionAgent"}, false},
+		{"20", []string{"payingAgent", "attorney"}, false},
+		{"128", nil, true},
+		{"посредник", nil, true},
+		{"комиссионер, посредник", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := getAgentsFromStr(tt.val)
+		if !reflect.DeepEqual(got, tt.want) || (err != nil) != tt.wantErr {
+			t.Errorf("getAgentsFromStr(\"%v\") = %v, %v, ожидалось %v, ошибка %v", tt.val, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+//writer json заданий драйвера атол для чека коррекции ФФД 1.05: без позиций, только суммы по типам оплат
+//и суммы НДС по ставкам (теги 1102-1107) с описанием коррекции (тег 1177). Данные, которых нет в чеке
+//коррекции ФФД 1.05 (марки, агенты, покупатель, реквизиты 1192/1084), отбрасываются с записью в отчёт
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const OUTFORMATATOL105 = "atol105"
+
+type TAtol105Writer struct{}
+
+type TCorrectionCheck105 struct {
+	Type                 string        `json:"type"` //sellCorrection, buyCorrection
+	TaxationType         string        `json:"taxationType,omitempty"`
+	CorrectionType       string        `json:"correctionType"`
+	CorrectionBaseName   string        `json:"correctionBaseName"` //тег 1177
+	CorrectionBaseDate   string        `json:"correctionBaseDate"`
+	CorrectionBaseNumber string        `json:"correctionBaseNumber"`
+	Operator             TOperator     `json:"operator"`
+	Payments             []TPayment    `json:"payments"`
+	Taxes                []TAtolTaxSum `json:"taxes"`
+}
+
+// ставки, для которых есть суммы в чеке коррекции ФФД 1.05: 1102 - 20%, 1103 - 10%, 1104 - 0%,
+// 1105 - без НДС, 1106 - 20/120, 1107 - 10/110
+var taxesOfFFD105 = map[string]bool{
+	STAVKANDS20: true, STAVKANDS10: true, STAVKANDS0: true, STAVKANDSNONE: true, STAVKANDS120: true, STAVKANDS110: true,
+}
+
+func (TAtol105Writer) FileExt() string {
+	return "json"
+}
+
+func (TAtol105Writer) Marshal(checkCorr TCorrection) ([]byte, error) {
+	task, err := getAtol105CheckOfCorrection(checkCorr)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(task, "", "\t")
+}
+
+// getAtol105CheckOfCorrection - задание чека коррекции ФФД 1.05 по модели чека. Возвратные коррекции
+// и ставки 5% и 7% в ФФД 1.05 невозможны - для них возвращается ошибка
+func getAtol105CheckOfCorrection(checkCorr TCorrection) (TCorrectionCheck105, error) {
+	var res TCorrectionCheck105
+	if checkCorr.Type != "sellCorrection" && checkCorr.Type != "buyCorrection" {
+		return res, fmt.Errorf("тип чека коррекции %v не поддерживается форматом %v (в ФФД 1.05 нет коррекции возврата)", checkCorr.Type, OUTFORMATATOL105)
+	}
+	res.Type = checkCorr.Type
+	res.TaxationType = checkCorr.TaxationType
+	res.CorrectionType = checkCorr.CorrectionType
+	res.CorrectionBaseName = checkCorr.CorrectionReason
+	res.CorrectionBaseDate = checkCorr.CorrectionBaseDate
+	res.CorrectionBaseNumber = checkCorr.CorrectionBaseNumber
+	res.Operator = checkCorr.Operator
+	res.Payments = checkCorr.Payments
+	for _, tax := range checkCorr.Taxes {
+		if !taxesOfFFD105[tax.Type] {
+			return res, fmt.Errorf("ставка НДС %v не поддерживается форматом %v", tax.Type, OUTFORMATATOL105)
+		}
+		sum := tax.Sum
+		if tax.Type == STAVKANDS0 || tax.Type == STAVKANDSNONE {
+			//для 0% и без НДС указывается сумма расчёта
+			sum = tax.Amount
+		}
+		res.Taxes = append(res.Taxes, TAtolTaxSum{Type: tax.Type, Sum: sum})
+	}
+	if unsupported := getUnsupportedOfFFD105(checkCorr); len(unsupported) > 0 {
+		logsmap[LOGREPORT].Printf("чек %v: в чек коррекции ФФД 1.05 не попадут: %v", checkCorr.Descr, strings.Join(unsupported, "; "))
+	}
+	return res, nil
+}
+
+// getUnsupportedOfFFD105 - данные чека, которые нельзя передать в чеке коррекции ФФД 1.05
+func getUnsupportedOfFFD105(checkCorr TCorrection) []string {
+	var res []string
+	countOfMarks := 0
+	countOfAgents := 0
+	for _, pos := range checkCorr.Positions {
+		if pos.Mark != "" {
+			countOfMarks++
+		}
+		if pos.AgentInfo != nil || pos.SupplierInfo != nil {
+			countOfAgents++
+		}
+	}
+	if countOfMarks > 0 {
+		res = append(res, fmt.Sprintf("коды маркировки (позиций %v)", countOfMarks))
+	}
+	if countOfAgents > 0 {
+		res = append(res, fmt.Sprintf("признаки агента и данные поставщика (позиций %v)", countOfAgents))
+	}
+	if !isEmptyClientInfo(checkCorr.ClientInfo) {
+		res = append(res, "данные покупателя (теги 1008, 1227, 1228, 1243-1246, 1254)")
+	}
+	if checkCorr.AdditionalAttribute != "" {
+		res = append(res, fmt.Sprintf("тег 1192 (%v)", checkCorr.AdditionalAttribute))
+	}
+	if len(checkCorr.UserAttributes) > 0 {
+		res = append(res, "тег 1084")
+	}
+	countOfIndustry := len(checkCorr.IndustryInfo)
+	for _, pos := range checkCorr.Positions {
+		countOfIndustry += len(pos.IndustryInfo)
+	}
+	if countOfIndustry > 0 {
+		res = append(res, fmt.Sprintf("отраслевые реквизиты (теги 1260, 1261: %v)", countOfIndustry))
+	}
+	return res
+}
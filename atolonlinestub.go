@@ -0,0 +1,112 @@
+package main
+
+//команда onlinestub: локальная замена АТОЛ Онлайн для проверки команды onlinesubmit. Слушает адрес из url
+//раздела [atolonline], выдает токен на любой логин, документы обрабатывает сразу. Документ с суммой оплат
+//меньше total отклоняется (статус fail), повторный external_id возвращает uuid принятого документа
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ATOLONLINESTUBTOKEN = "stubtoken"
+
+type TAtolOnlineStub struct {
+	mu          sync.Mutex
+	prefix      string                       //путь из url, например /possystem/v5
+	reports     map[string]TAtolOnlineReport //по uuid
+	externalIDs map[string]string            //uuid по external_id
+	fd          int
+}
+
+func writeAtolOnlineStubAnswer(w http.ResponseWriter, code int, answer interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(answer)
+}
+
+func (stub *TAtolOnlineStub) execute(doc TAtolOnlineDocument) TAtolOnlineReport {
+	res := TAtolOnlineReport{UUID: newUUID(), Timestamp: time.Now().Format("02.01.2006 15:04:05")}
+	payments := 0.0
+	for _, pay := range doc.Receipt.Payments {
+		payments += pay.Sum
+	}
+	if roundKopecks(payments) < roundKopecks(doc.Receipt.Total) {
+		res.Status = "fail"
+		res.Error = &TAtolOnlineError{Code: 1, Type: "agent", Text: fmt.Sprintf("сумма оплат %v меньше итога %v", payments, doc.Receipt.Total)}
+		return res
+	}
+	stub.fd++
+	res.Status = "done"
+	res.Payload = &TAtolOnlinePayload{FiscalReceiptNumber: stub.fd - 1000, ShiftNumber: 1, ReceiptDatetime: res.Timestamp,
+		Total: doc.Receipt.Total, FnNumber: "9999078900000000", FiscalDocumentNumber: stub.fd, FiscalDocumentAttribute: int64(stub.fd) * 7919}
+	return res
+}
+
+func (stub *TAtolOnlineStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, stub.prefix), "/")
+	parts := strings.Split(path, "/")
+	if path == "getToken" && r.Method == http.MethodPost {
+		var req TAtolOnlineTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Login == "" {
+			writeAtolOnlineStubAnswer(w, http.StatusBadRequest, TAtolOnlineTokenResponse{Error: &TAtolOnlineError{Code: 12, Type: "system", Text: "неверный логин"}})
+			return
+		}
+		writeAtolOnlineStubAnswer(w, http.StatusOK, TAtolOnlineTokenResponse{Token: ATOLONLINESTUBTOKEN})
+		return
+	}
+	if r.Header.Get("Token") != ATOLONLINESTUBTOKEN {
+		writeAtolOnlineStubAnswer(w, http.StatusUnauthorized, TAtolOnlineReport{Error: &TAtolOnlineError{Code: 11, Type: "system", Text: "неверный токен"}})
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 2 && strings.HasSuffix(parts[1], "_correction"):
+		var doc TAtolOnlineDocument
+		if err := json.NewDecoder(r.Body).Decode(&doc); err != nil || doc.ExternalID == "" {
+			writeAtolOnlineStubAnswer(w, http.StatusBadRequest, TAtolOnlineReport{Error: &TAtolOnlineError{Code: 2, Type: "system", Text: "неверный документ"}})
+			return
+		}
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		if uuid, exist := stub.externalIDs[doc.ExternalID]; exist {
+			writeAtolOnlineStubAnswer(w, http.StatusBadRequest, TAtolOnlineReport{UUID: uuid, Status: "fail",
+				Error: &TAtolOnlineError{Code: ATOLONLINEERRORDUPLICATE, Type: "system", Text: "документ с таким external_id уже существует"}})
+			return
+		}
+		res := stub.execute(doc)
+		stub.reports[res.UUID] = res
+		stub.externalIDs[doc.ExternalID] = res.UUID
+		logsmap[LOGINFO_WITHSTD].Printf("заглушка АТОЛ Онлайн: документ %v (%v) обработан со статусом %v", doc.ExternalID, parts[1], res.Status)
+		writeAtolOnlineStubAnswer(w, http.StatusOK, TAtolOnlineReport{UUID: res.UUID, Status: "wait", Timestamp: res.Timestamp})
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[1] == "report":
+		stub.mu.Lock()
+		res, exist := stub.reports[parts[2]]
+		stub.mu.Unlock()
+		if !exist {
+			writeAtolOnlineStubAnswer(w, http.StatusNotFound, TAtolOnlineReport{Error: &TAtolOnlineError{Code: 34, Type: "system", Text: "документ не найден"}})
+			return
+		}
+		writeAtolOnlineStubAnswer(w, http.StatusOK, res)
+	default:
+		http.Error(w, "не поддерживается", http.StatusNotFound)
+	}
+}
+
+// runAtolOnlineStub - запуск заглушки на адресе из url раздела [atolonline]
+func runAtolOnlineStub() {
+	u, err := url.Parse(AtolOnline.URL)
+	if err != nil || u.Host == "" {
+		logsmap[LOGERROR].Printf("неверный url %v в разделе [atolonline] файла init.toml", AtolOnline.URL)
+		return
+	}
+	stub := &TAtolOnlineStub{prefix: strings.TrimRight(u.Path, "/"), reports: make(map[string]TAtolOnlineReport),
+		externalIDs: make(map[string]string), fd: 1000}
+	logsmap[LOGINFO_WITHSTD].Printf("заглушка АТОЛ Онлайн запущена на %v", u.Host)
+	if err := http.ListenAndServe(u.Host, stub); err != nil {
+		logsmap[LOGERROR].Printf("ошибка (%v) работы заглушки АТОЛ Онлайн", err)
+	}
+}
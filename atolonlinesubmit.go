@@ -0,0 +1,269 @@
+package main
+
+//команда onlinesubmit: отправка документов чеков коррекции в АТОЛ Онлайн (getToken, операция, report/{uuid})
+//с журналом printed.txt и результатами results.csv в папке ФН, как у команды submit
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const COMMANDATOLONLINESUBMIT = "onlinesubmit"
+const COMMANDATOLONLINESTUB = "onlinestub"
+
+// код ошибки АТОЛ Онлайн: документ с таким external_id уже принят (в ответе uuid принятого документа)
+const ATOLONLINEERRORDUPLICATE = 10
+
+type TAtolOnlineError struct {
+	Code int    `json:"code"`
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type TAtolOnlineTokenRequest struct {
+	Login string `json:"login"`
+	Pass  string `json:"pass"`
+}
+
+type TAtolOnlineTokenResponse struct {
+	Token     string            `json:"token"`
+	Error     *TAtolOnlineError `json:"error"`
+	Timestamp string            `json:"timestamp"`
+}
+
+type TAtolOnlinePayload struct {
+	FiscalReceiptNumber     int     `json:"fiscal_receipt_number"`
+	ShiftNumber             int     `json:"shift_number"`
+	ReceiptDatetime         string  `json:"receipt_datetime"`
+	Total                   float64 `json:"total"`
+	FnNumber                string  `json:"fn_number"`
+	EcrRegistrationNumber   string  `json:"ecr_registration_number"`
+	FiscalDocumentNumber    int     `json:"fiscal_document_number"`
+	FiscalDocumentAttribute int64   `json:"fiscal_document_attribute"`
+}
+
+// ответ на отправку документа и на запрос report/{uuid}
+type TAtolOnlineReport struct {
+	UUID      string              `json:"uuid"`
+	Status    string              `json:"status"` //wait, done, fail
+	Error     *TAtolOnlineError   `json:"error"`
+	Payload   *TAtolOnlinePayload `json:"payload,omitempty"`
+	Timestamp string              `json:"timestamp"`
+}
+
+// isAtolOnlineTask - файл задания является документом АТОЛ Онлайн (а не заданием драйвера атол)
+func isAtolOnlineTask(content []byte) bool {
+	var task struct {
+		Operation string `json:"operation"`
+	}
+	return json.Unmarshal(content, &task) == nil && task.Operation != ""
+}
+
+func getAtolOnlineError(apiErr *TAtolOnlineError) string {
+	if apiErr == nil {
+		return ""
+	}
+	return fmt.Sprintf("ошибка %v (%v): %v", apiErr.Code, apiErr.Type, apiErr.Text)
+}
+
+// getAtolOnlineToken - токен для запросов АТОЛ Онлайн (действует 24 часа, запрашивается один раз за запуск)
+func getAtolOnlineToken() (string, error) {
+	body, _ := json.Marshal(TAtolOnlineTokenRequest{Login: AtolOnline.Login, Pass: AtolOnline.Pass})
+	resp, err := httpClientOfAtol.Post(AtolOnline.URL+"/getToken", "application/json; charset=utf-8", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var answer TAtolOnlineTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return "", fmt.Errorf("ошибка (%v) разбора ответа getToken (код %v)", err, resp.StatusCode)
+	}
+	if answer.Error != nil || answer.Token == "" {
+		return "", fmt.Errorf("токен не получен: %v", getAtolOnlineError(answer.Error))
+	}
+	return answer.Token, nil
+}
+
+func doAtolOnlineRequest(method, url, token string, body []byte) (TAtolOnlineReport, error) {
+	var res TAtolOnlineReport
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return res, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Token", token)
+	resp, err := httpClientOfAtol.Do(req)
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+	answer, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return res, err
+	}
+	if err := json.Unmarshal(answer, &res); err != nil {
+		return res, fmt.Errorf("АТОЛ Онлайн вернул код %v: %v", resp.StatusCode, strings.TrimSpace(string(answer)))
+	}
+	return res, nil
+}
+
+// postAtolOnlineDocument - отправка документа. Повторно отправленный документ (тот же external_id)
+// не регистрируется, а возвращается uuid ранее принятого
+func postAtolOnlineDocument(token, fileOfTask string, content []byte) (string, error) {
+	var task TAtolOnlineTask
+	if err := json.Unmarshal(content, &task); err != nil {
+		return "", fmt.Errorf("ошибка (%v) разбора документа", err)
+	}
+	task.Document.Timestamp = time.Now().Format("02.01.2006 15:04:05")
+	if task.Document.ExternalID == "" {
+		task.Document.ExternalID = strings.TrimSuffix(filepath.Base(fileOfTask), filepath.Ext(fileOfTask))
+	}
+	body, err := json.Marshal(task.Document)
+	if err != nil {
+		return "", err
+	}
+	res, err := doAtolOnlineRequest(http.MethodPost, fmt.Sprintf("%v/%v/%v", AtolOnline.URL, AtolOnline.GroupCode, task.Operation), token, body)
+	if err != nil {
+		return "", err
+	}
+	if res.Error != nil && !(res.Error.Code == ATOLONLINEERRORDUPLICATE && res.UUID != "") {
+		return "", errors.New(getAtolOnlineError(res.Error))
+	}
+	if res.UUID == "" {
+		return "", errors.New("АТОЛ Онлайн не вернул uuid документа")
+	}
+	return res.UUID, nil
+}
+
+// waitAtolOnlineReport - опрос report/{uuid} до статуса done или fail
+func waitAtolOnlineReport(token, uuid string) (TAtolOnlineReport, error) {
+	deadline := time.Now().Add(time.Duration(*submitTimeout) * time.Second)
+	for {
+		res, err := doAtolOnlineRequest(http.MethodGet, fmt.Sprintf("%v/%v/report/%v", AtolOnline.URL, AtolOnline.GroupCode, uuid), token, nil)
+		if err != nil {
+			return res, err
+		}
+		if res.Status == "done" || res.Status == "fail" {
+			return res, nil
+		}
+		if time.Now().After(deadline) {
+			return res, fmt.Errorf("документ %v не обработан за %v секунд (статус %v)", uuid, *submitTimeout, res.Status)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// submitAtolOnlineTask - отправка одного документа с учётом журнала. Перед отправкой в журнал пишется
+// запись без uuid, после ответа - с uuid; после прерывания документ без uuid отправляется повторно
+// (АТОЛ Онлайн вернет uuid по external_id), а для документа с uuid запрашивается отчёт
+func submitAtolOnlineTask(token, dirOfFN, fileOfTask string, content []byte, prev TSubmitJournalRecord, existPrev bool) (TSubmitJournalRecord, error) {
+	rec := TSubmitJournalRecord{File: fileOfTask, Status: SUBMITSTATUSSENT}
+	if existPrev && prev.Status == SUBMITSTATUSSENT {
+		rec.UUID = prev.UUID
+	}
+	if rec.UUID == "" {
+		if err := addToSubmitJournal(dirOfFN, rec); err != nil {
+			return rec, err
+		}
+		uuid, err := postAtolOnlineDocument(token, fileOfTask, content)
+		if err != nil {
+			return rec, err
+		}
+		rec.UUID = uuid
+		if err := addToSubmitJournal(dirOfFN, rec); err != nil {
+			return rec, err
+		}
+	}
+	report, err := waitAtolOnlineReport(token, rec.UUID)
+	if err != nil {
+		return rec, err
+	}
+	if report.Status != "done" {
+		rec.Status = SUBMITSTATUSERROR
+		rec.Description = fmt.Sprintf("статус %v, %v", report.Status, getAtolOnlineError(report.Error))
+	} else {
+		rec.Status = SUBMITSTATUSREADY
+		if report.Payload != nil {
+			rec.FD = fmt.Sprint(report.Payload.FiscalDocumentNumber)
+			rec.FP = fmt.Sprint(report.Payload.FiscalDocumentAttribute)
+			rec.Shift = fmt.Sprint(report.Payload.ShiftNumber)
+		}
+	}
+	if err := addToSubmitJournal(dirOfFN, rec); err != nil {
+		return rec, err
+	}
+	return rec, nil
+}
+
+// submitAtolOnlineOfFN - отправка документов АТОЛ Онлайн папки ФН по порядку имен файлов
+func submitAtolOnlineOfFN(token, dirOfFN string) (int, error) {
+	tasks, err := getTasksOfFN(dirOfFN)
+	if err != nil {
+		return 0, err
+	}
+	journal := readSubmitJournal(dirOfFN)
+	countOfSubmitted := 0
+	for _, fileOfTask := range tasks {
+		prev, existPrev := journal[fileOfTask]
+		if existPrev && prev.Status == SUBMITSTATUSREADY {
+			continue
+		}
+		content, err := os.ReadFile(dirOfFN + fileOfTask)
+		if err != nil {
+			return countOfSubmitted, err
+		}
+		if !isAtolOnlineTask(content) {
+			continue
+		}
+		logsmap[LOGINFO_WITHSTD].Printf("отправка документа %v%v в АТОЛ Онлайн", dirOfFN, fileOfTask)
+		rec, err := submitAtolOnlineTask(token, dirOfFN, fileOfTask, content, prev, existPrev)
+		if err != nil {
+			return countOfSubmitted, fmt.Errorf("документ %v: %v", fileOfTask, err)
+		}
+		if rec.Status == SUBMITSTATUSERROR {
+			return countOfSubmitted, fmt.Errorf("документ %v не обработан: %v", fileOfTask, rec.Description)
+		}
+		countOfSubmitted++
+		if err := addToSubmitResults(dirOfFN, rec); err != nil {
+			logsmap[LOGERROR].Printf("ошибка (%v) записи результата документа %v", err, fileOfTask)
+		}
+		logsmap[LOGREPORT].Printf("документ %v%v обработан АТОЛ Онлайн: ФД %v, ФП %v, смена %v", dirOfFN, fileOfTask, rec.FD, rec.FP, rec.Shift)
+	}
+	return countOfSubmitted, nil
+}
+
+// runAtolOnlineSubmit - команда onlinesubmit: отправка документов АТОЛ Онлайн всех ФН из папки json
+func runAtolOnlineSubmit() {
+	if AtolOnline.URL == "" || AtolOnline.Login == "" || AtolOnline.GroupCode == "" {
+		logsmap[LOGERROR].Println("в разделе [atolonline] файла init.toml должны быть заполнены url, login, pass и groupcode")
+		return
+	}
+	token, err := getAtolOnlineToken()
+	if err != nil {
+		logsmap[LOGERROR].Printf("ошибка (%v) авторизации в АТОЛ Онлайн %v", err, AtolOnline.URL)
+		return
+	}
+	entries, err := os.ReadDir(JSONRES)
+	if err != nil {
+		logsmap[LOGERROR].Printf("ошибка (%v) чтения папки %v", err, JSONRES)
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dirOfFN := JSONRES + entry.Name() + "/"
+		countOfSubmitted, err := submitAtolOnlineOfFN(token, dirOfFN)
+		if err != nil {
+			logsmap[LOGERROR].Printf("отправка документов ФН %v прервана: %v", entry.Name(), err)
+		}
+		logsmap[LOGINFO_WITHSTD].Printf("для ФН %v в АТОЛ Онлайн обработано %v документов", entry.Name(), countOfSubmitted)
+	}
+}
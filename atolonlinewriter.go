@@ -0,0 +1,367 @@
+package main
+
+//writer документов облачной кассы АТОЛ Онлайн (API v5, операции sell_correction, buy_correction,
+//sell_refund_correction, buy_refund_correction). Файл задания содержит операцию и документ, который
+//команда onlinesubmit отправляет на {url}/{groupcode}/{операция}
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const OUTFORMATATOLONLINE = "atolonline"
+
+// настройки АТОЛ Онлайн (раздел [atolonline] файла init.toml)
+type TAtolOnlineConfig struct {
+	URL            string
+	Login          string
+	Pass           string
+	GroupCode      string
+	INN            string //ИНН организации, если его нет в данных ОФД
+	PaymentAddress string //место расчётов (тег 1187)
+	Email          string //email организации (тег 1117)
+}
+
+var AtolOnline TAtolOnlineConfig
+
+type TAtolOnlineWriter struct{}
+
+// файл задания: операция API и документ
+type TAtolOnlineTask struct {
+	Operation string              `json:"operation"`
+	Document  TAtolOnlineDocument `json:"document"`
+}
+
+type TAtolOnlineDocument struct {
+	Timestamp  string             `json:"timestamp"`   //ДД.ММ.ГГГГ ЧЧ:ММ:СС, обновляется при отправке
+	ExternalID string             `json:"external_id"` //заполняется при отправке именем файла задания
+	Receipt    TAtolOnlineReceipt `json:"receipt"`
+}
+
+type TAtolOnlineReceipt struct {
+	Client               *TAtolOnlineClient    `json:"client,omitempty"`
+	Company              TAtolOnlineCompany    `json:"company"`
+	CorrectionInfo       TAtolOnlineCorrInfo   `json:"correction_info"`
+	Items                []TAtolOnlineItem     `json:"items"`
+	Payments             []TAtolOnlinePayment  `json:"payments"`
+	Vats                 []TAtolOnlineVat      `json:"vats"`
+	Total                float64               `json:"total"`
+	Cashier              string                `json:"cashier,omitempty"`
+	CashierINN           string                `json:"cashier_inn,omitempty"`
+	AdditionalCheckProps string                `json:"additional_check_props,omitempty"` //тег 1192
+	AdditionalUserProps  *TAtolOnlineUserProps `json:"additional_user_props,omitempty"`  //тег 1084
+	SectoralCheckProps   []TAtolOnlineSectoral `json:"sectoral_check_props,omitempty"`   //тег 1261
+}
+
+// отраслевой реквизит (теги 1260, 1261)
+type TAtolOnlineSectoral struct {
+	FederalID string `json:"federal_id"`
+	Date      string `json:"date"`
+	Number    string `json:"number"`
+	Value     string `json:"value"`
+}
+
+type TAtolOnlineClient struct {
+	Email        string `json:"email,omitempty"`
+	Phone        string `json:"phone,omitempty"`
+	Name         string `json:"name,omitempty"`
+	INN          string `json:"inn,omitempty"`
+	BirthDate    string `json:"birthdate,omitempty"`
+	Citizenship  string `json:"citizenship,omitempty"`
+	DocumentCode string `json:"document_code,omitempty"`
+	DocumentData string `json:"document_data,omitempty"`
+	Address      string `json:"address,omitempty"`
+}
+
+type TAtolOnlineCompany struct {
+	Email          string `json:"email"`
+	Sno            string `json:"sno,omitempty"`
+	INN            string `json:"inn"`
+	PaymentAddress string `json:"payment_address"`
+}
+
+type TAtolOnlineCorrInfo struct {
+	Type       string `json:"type"`      //self, instruction
+	BaseDate   string `json:"base_date"` //ДД.ММ.ГГГГ
+	BaseNumber string `json:"base_number,omitempty"`
+}
+
+type TAtolOnlineItem struct {
+	Name               string                   `json:"name"`
+	Price              float64                  `json:"price"`
+	Quantity           float64                  `json:"quantity"`
+	Sum                float64                  `json:"sum"`
+	Measure            int                      `json:"measure"`        //тег 2108
+	PaymentMethod      string                   `json:"payment_method"` //full_payment, prepayment ...
+	PaymentObject      int                      `json:"payment_object"` //тег 1212
+	Vat                TAtolOnlineVat           `json:"vat"`
+	MarkProcessingMode string                   `json:"mark_processing_mode,omitempty"`
+	MarkCode           map[string]string        `json:"mark_code,omitempty"`
+	AgentInfo          *TAtolOnlineAgentInfo    `json:"agent_info,omitempty"`
+	SupplierInfo       *TAtolOnlineSupplierInfo `json:"supplier_info,omitempty"`
+	SectoralItemProps  []TAtolOnlineSectoral    `json:"sectoral_item_props,omitempty"` //тег 1260
+}
+
+type TAtolOnlineVat struct {
+	Type string  `json:"type"`
+	Sum  float64 `json:"sum,omitempty"`
+}
+
+type TAtolOnlinePayment struct {
+	Type int     `json:"type"` //0 - наличными, 1 - безналичными, 2 - зачёт аванса, 3 - в кредит, 4 - встречным представлением
+	Sum  float64 `json:"sum"`
+}
+
+type TAtolOnlineAgentInfo struct {
+	Type                    string                              `json:"type"`
+	PayingAgent             *TAtolOnlinePayingAgent             `json:"paying_agent,omitempty"`
+	ReceivePaymentsOperator *TAtolOnlineReceivePaymentsOperator `json:"receive_payments_operator,omitempty"`
+	MoneyTransferOperator   *TAtolOnlineMoneyTransferOperator   `json:"money_transfer_operator,omitempty"`
+}
+
+type TAtolOnlinePayingAgent struct {
+	Operation string   `json:"operation,omitempty"`
+	Phones    []string `json:"phones,omitempty"`
+}
+
+type TAtolOnlineReceivePaymentsOperator struct {
+	Phones []string `json:"phones,omitempty"`
+}
+
+type TAtolOnlineMoneyTransferOperator struct {
+	Phones  []string `json:"phones,omitempty"`
+	Name    string   `json:"name,omitempty"`
+	Address string   `json:"address,omitempty"`
+	INN     string   `json:"inn,omitempty"`
+}
+
+type TAtolOnlineSupplierInfo struct {
+	Phones []string `json:"phones,omitempty"`
+	Name   string   `json:"name,omitempty"`
+	INN    string   `json:"inn,omitempty"`
+}
+
+type TAtolOnlineUserProps struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+var atolOnlineOperations = map[string]string{
+	"sellCorrection": "sell_correction", "buyCorrection": "buy_correction",
+	"sellReturnCorrection": "sell_refund_correction", "buyReturnCorrection": "buy_refund_correction",
+}
+
+var atolOnlineSno = map[string]string{
+	"osn": "osn", "usnIncome": "usn_income", "usnIncomeOutcome": "usn_income_outcome", "envd": "envd", "esn": "esn", "patent": "patent",
+}
+
+var atolOnlinePaymentMethods = map[string]string{
+	"fullPrepayment": "full_prepayment", "prepayment": "prepayment", "advance": "advance", "fullPayment": "full_payment",
+	"partialPayment": "partial_payment", "credit": "credit", "creditPayment": "credit_payment",
+}
+
+var atolOnlinePayments = map[string]int{
+	"cash": 0, "electronically": 1, "prepaid": 2, "credit": 3, "other": 4,
+}
+
+var atolOnlineAgents = map[string]string{
+	"bankPayingAgent": "bank_paying_agent", "bankPayingSubagent": "bank_paying_subagent", "payingAgent": "paying_agent",
+	"payingSubagent": "paying_subagent", "attorney": "attorney", "commissionAgent": "commission_agent", "another": "another",
+}
+
+// ключи mark_code для кодов товара без проверки КМ
+var atolOnlineProductCodes = map[string]string{
+	"Undefined": "unknown", "EAN_8": "ean", "EAN_13": "ean13", "ITF_14": "itf14",
+}
+
+// initAtolOnline - чтение раздела [atolonline] файла init.toml
+func initAtolOnline(data map[string]interface{}) {
+	AtolOnline = TAtolOnlineConfig{}
+	aolinit, ok := data["atolonline"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	getStr := func(name string) string {
+		if val, ok := aolinit[name]; ok {
+			return strings.TrimSpace(fmt.Sprint(val))
+		}
+		return ""
+	}
+	AtolOnline.URL = strings.TrimRight(getStr("url"), "/")
+	AtolOnline.Login = getStr("login")
+	AtolOnline.Pass = getStr("pass")
+	AtolOnline.GroupCode = getStr("groupcode")
+	AtolOnline.INN = getStr("inn")
+	AtolOnline.PaymentAddress = getStr("paymentaddress")
+	AtolOnline.Email = getStr("email")
+	logginInFile(fmt.Sprintf("AtolOnline: url=%v, login=%v, groupcode=%v, inn=%v, paymentaddress=%v, email=%v", AtolOnline.URL,
+		AtolOnline.Login, AtolOnline.GroupCode, AtolOnline.INN, AtolOnline.PaymentAddress, AtolOnline.Email))
+}
+
+// checkAtolOnlineForDocuments - настройки, без которых документ АТОЛ Онлайн не будет принят
+func checkAtolOnlineForDocuments() error {
+	if AtolOnline.PaymentAddress == "" || AtolOnline.Email == "" {
+		return errors.New("в разделе [atolonline] файла init.toml должны быть заполнены paymentaddress и email организации")
+	}
+	return nil
+}
+
+func (TAtolOnlineWriter) FileExt() string {
+	return "json"
+}
+
+func (TAtolOnlineWriter) Marshal(checkCorr TCorrection) ([]byte, error) {
+	task, err := getAtolOnlineTaskOfCorrection(checkCorr)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(task, "", "\t")
+}
+
+func getAtolOnlineValue(values map[string]string, val, descr string) (string, error) {
+	res, ok := values[val]
+	if !ok {
+		return "", fmt.Errorf("значение \"%v\" (%v) не поддерживается форматом %v", val, descr, OUTFORMATATOLONLINE)
+	}
+	return res, nil
+}
+
+// getAtolOnlineTaskOfCorrection - документ чека коррекции АТОЛ Онлайн по модели чека
+func getAtolOnlineTaskOfCorrection(checkCorr TCorrection) (TAtolOnlineTask, error) {
+	var task TAtolOnlineTask
+	var err error
+	if task.Operation, err = getAtolOnlineValue(atolOnlineOperations, checkCorr.Type, "тип чека коррекции"); err != nil {
+		return task, err
+	}
+	task.Document.Timestamp = time.Now().Format("02.01.2006 15:04:05")
+	receipt := &task.Document.Receipt
+	receipt.Company.Email = AtolOnline.Email
+	receipt.Company.PaymentAddress = AtolOnline.PaymentAddress
+	receipt.Company.INN = checkCorr.INN
+	if receipt.Company.INN == "" {
+		receipt.Company.INN = AtolOnline.INN
+	}
+	if receipt.Company.INN == "" {
+		return task, errors.New("нет ИНН организации (нет в данных ОФД и в поле inn раздела [atolonline])")
+	}
+	if checkCorr.TaxationType != "" {
+		if receipt.Company.Sno, err = getAtolOnlineValue(atolOnlineSno, checkCorr.TaxationType, "система налогообложения"); err != nil {
+			return task, err
+		}
+	}
+	if client := checkCorr.ClientInfo; !isEmptyClientInfo(client) {
+		receipt.Client = &TAtolOnlineClient{Name: client.Name, INN: client.Vatin, BirthDate: client.BirthDate, Citizenship: client.Citizenship,
+			DocumentCode: client.IdentityDocumentCode, DocumentData: client.IdentityDocumentData, Address: client.Address}
+		if strings.Contains(client.EmailOrPhone, "@") {
+			receipt.Client.Email = client.EmailOrPhone
+		} else {
+			receipt.Client.Phone = client.EmailOrPhone
+		}
+	}
+	receipt.CorrectionInfo.Type = checkCorr.CorrectionType
+	receipt.CorrectionInfo.BaseDate = checkCorr.CorrectionBaseDate
+	if dateOfBase, errDate := time.Parse("2006.01.02", checkCorr.CorrectionBaseDate); errDate == nil {
+		receipt.CorrectionInfo.BaseDate = dateOfBase.Format("02.01.2006")
+	}
+	receipt.CorrectionInfo.BaseNumber = checkCorr.CorrectionBaseNumber
+	receipt.Cashier = checkCorr.Operator.Name
+	receipt.CashierINN = checkCorr.Operator.Vatin
+	receipt.AdditionalCheckProps = checkCorr.AdditionalAttribute
+	if len(checkCorr.UserAttributes) > 0 {
+		//в документе АТОЛ Онлайн один дополнительный реквизит пользователя
+		attr := checkCorr.UserAttributes[0]
+		receipt.AdditionalUserProps = &TAtolOnlineUserProps{Name: attr.Name, Value: attr.Value}
+	}
+	receipt.SectoralCheckProps = getAtolOnlineSectoral(checkCorr.IndustryInfo)
+	for _, pos := range checkCorr.Positions {
+		item, err := getAtolOnlineItemOfPosition(pos)
+		if err != nil {
+			return task, fmt.Errorf("позиция %v: %v", pos.Name, err)
+		}
+		receipt.Items = append(receipt.Items, item)
+	}
+	receipt.Total = checkCorr.Total
+	for _, tax := range checkCorr.Taxes {
+		receipt.Vats = append(receipt.Vats, TAtolOnlineVat{Type: tax.Type, Sum: tax.Sum})
+	}
+	for _, pay := range checkCorr.Payments {
+		typeOfPay, ok := atolOnlinePayments[pay.Type]
+		if !ok {
+			return task, fmt.Errorf("значение \"%v\" (тип оплаты) не поддерживается форматом %v", pay.Type, OUTFORMATATOLONLINE)
+		}
+		if math.Abs(pay.Sum) < 0.005 {
+			continue
+		}
+		receipt.Payments = append(receipt.Payments, TAtolOnlinePayment{Type: typeOfPay, Sum: pay.Sum})
+	}
+	return task, nil
+}
+
+func getAtolOnlineItemOfPosition(pos TCorrectionPosition) (TAtolOnlineItem, error) {
+	var item TAtolOnlineItem
+	var err error
+	item.Name = pos.Name
+	item.Price = pos.Price
+	item.Quantity = pos.Quantity
+	item.Sum = pos.Amount
+	if item.Measure, err = getCodeOfMeasUnit(pos.MeasurementUnit); err != nil {
+		return item, err
+	}
+	if item.PaymentMethod, err = getAtolOnlineValue(atolOnlinePaymentMethods, pos.PaymentMethod, "способ расчёта"); err != nil {
+		return item, err
+	}
+	//коды предмета расчёта (тег 1212) те же, что и в формате Штрих-М
+	if item.PaymentObject, err = getShtrihCode(shtrihPaymentObjects, pos.PaymentObject, "предмет расчёта"); err != nil {
+		return item, err
+	}
+	if _, ok := shtrihTaxes[pos.Tax]; !ok {
+		return item, fmt.Errorf("значение \"%v\" (ставка НДС) не поддерживается форматом %v", pos.Tax, OUTFORMATATOLONLINE)
+	}
+	item.Vat = TAtolOnlineVat{Type: pos.Tax, Sum: getSummNDS(pos.Amount, pos.Tax)}
+	if pos.Mark != "" {
+		if isProductCodeOfMark(pos.MarkType) {
+			item.MarkCode = map[string]string{atolOnlineProductCodes[pos.MarkType]: pos.Mark}
+		} else {
+			item.MarkProcessingMode = "0"
+			item.MarkCode = map[string]string{"gs1m": base64.StdEncoding.EncodeToString([]byte(pos.Mark))}
+		}
+	}
+	if pos.AgentInfo != nil {
+		//в документе АТОЛ Онлайн у позиции один признак агента
+		if len(pos.AgentInfo.Agents) != 1 {
+			return item, fmt.Errorf("у позиции %v несколько признаков агента (%v), формат %v допускает один", pos.Name, strings.Join(pos.AgentInfo.Agents, ", "), OUTFORMATATOLONLINE)
+		}
+		typeOfAgent, err := getAtolOnlineValue(atolOnlineAgents, pos.AgentInfo.Agents[0], "признак агента")
+		if err != nil {
+			return item, err
+		}
+		item.AgentInfo = &TAtolOnlineAgentInfo{Type: typeOfAgent}
+		if payingAgent := pos.AgentInfo.PayingAgent; payingAgent != nil {
+			item.AgentInfo.PayingAgent = &TAtolOnlinePayingAgent{Operation: payingAgent.Operation, Phones: payingAgent.Phones}
+		}
+		if receiveOper := pos.AgentInfo.ReceivePaymentsOperator; receiveOper != nil {
+			item.AgentInfo.ReceivePaymentsOperator = &TAtolOnlineReceivePaymentsOperator{Phones: receiveOper.Phones}
+		}
+		if transferOper := pos.AgentInfo.MoneyTransferOperator; transferOper != nil {
+			item.AgentInfo.MoneyTransferOperator = &TAtolOnlineMoneyTransferOperator{Phones: transferOper.Phones,
+				Name: transferOper.Name, Address: transferOper.Address, INN: transferOper.Vatin}
+		}
+	}
+	if pos.SupplierInfo != nil {
+		item.SupplierInfo = &TAtolOnlineSupplierInfo{Phones: pos.SupplierInfo.Phones, Name: pos.SupplierInfo.Name, INN: pos.SupplierInfo.Vatin}
+	}
+	item.SectoralItemProps = getAtolOnlineSectoral(pos.IndustryInfo)
+	return item, nil
+}
+
+func getAtolOnlineSectoral(industryInfo []TIndustryInfo) []TAtolOnlineSectoral {
+	var res []TAtolOnlineSectoral
+	for _, info := range industryInfo {
+		res = append(res, TAtolOnlineSectoral{FederalID: info.Fois, Date: info.Date, Number: info.Number, Value: info.IndustryAttribute})
+	}
+	return res
+}
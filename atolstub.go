@@ -0,0 +1,123 @@
+package main
+
+//команда atolstub: локальная замена веб-сервера драйвера атол для проверки команды submit.
+//Задания выполняются сразу, фискальные данные выдумываются, чек с суммой оплат меньше суммы позиций отклоняется
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TAtolStub struct {
+	mu      sync.Mutex
+	results map[string]TAtolResult
+	fd      int
+	shift   int
+	numb    int
+}
+
+// задание атол в объеме, нужном заглушке
+type TAtolStubTask struct {
+	Type  string `json:"type"`
+	Items []struct {
+		Type   string  `json:"type"`
+		Amount float64 `json:"amount"`
+	} `json:"items"`
+	Payments []TPayment `json:"payments"`
+}
+
+func (stub *TAtolStub) execute(rawTask json.RawMessage) TAtolResult {
+	var task TAtolStubTask
+	if err := json.Unmarshal(rawTask, &task); err != nil {
+		return TAtolResult{Status: "error", ErrorCode: 1, ErrorDescription: fmt.Sprintf("ошибка разбора задания: %v", err)}
+	}
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	switch task.Type {
+	case "openShift":
+		stub.shift++
+		stub.numb = 0
+	case "closeShift", "clearMarkingCodeValidationResult":
+	default:
+		if !strings.HasSuffix(task.Type, "Correction") {
+			return TAtolResult{Status: "error", ErrorCode: 2, ErrorDescription: fmt.Sprintf("неизвестный тип задания %v", task.Type)}
+		}
+		total, payments := 0.0, 0.0
+		for _, item := range task.Items {
+			if item.Type == "position" {
+				total += item.Amount
+			}
+		}
+		for _, pay := range task.Payments {
+			payments += pay.Sum
+		}
+		if roundKopecks(payments) < roundKopecks(total) {
+			return TAtolResult{Status: "error", ErrorCode: 3, ErrorDescription: fmt.Sprintf("сумма оплат %v меньше суммы чека %v", payments, total)}
+		}
+		stub.numb++
+	}
+	if task.Type == "clearMarkingCodeValidationResult" {
+		return TAtolResult{Status: "ready"}
+	}
+	stub.fd++
+	res := TAtolResult{Status: "ready"}
+	res.Result = &struct {
+		FiscalParams *TAtolFiscalParams `json:"fiscalParams,omitempty"`
+	}{FiscalParams: &TAtolFiscalParams{FiscalDocumentNumber: stub.fd, FiscalDocumentSign: fmt.Sprintf("%010d", stub.fd*7919),
+		FiscalReceiptNumber: stub.numb, ShiftNumber: stub.shift, FiscalDocumentDateTime: time.Now().Format("2006-01-02T15:04:05")}}
+	return res
+}
+
+func (stub *TAtolStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	uuid, isRequestOfUUID := strings.CutPrefix(r.URL.Path, ATOLAPIREQUESTS+"/")
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == ATOLAPIREQUESTS:
+		var req TAtolRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UUID == "" || len(req.Request) == 0 {
+			http.Error(w, "неверный запрос", http.StatusBadRequest)
+			return
+		}
+		stub.mu.Lock()
+		_, exist := stub.results[req.UUID]
+		stub.mu.Unlock()
+		if exist {
+			http.Error(w, "задание с таким uuid уже существует", http.StatusConflict)
+			return
+		}
+		res := stub.execute(req.Request[0])
+		stub.mu.Lock()
+		stub.results[req.UUID] = res
+		stub.mu.Unlock()
+		logsmap[LOGINFO_WITHSTD].Printf("заглушка атол: задание %v выполнено со статусом %v", req.UUID, res.Status)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet && isRequestOfUUID:
+		stub.mu.Lock()
+		res, exist := stub.results[uuid]
+		stub.mu.Unlock()
+		if !exist {
+			http.Error(w, "задание не найдено", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TAtolResponse{Results: []TAtolResult{res}})
+	default:
+		http.Error(w, "не поддерживается", http.StatusNotFound)
+	}
+}
+
+// runAtolStub - запуск заглушки на адресе из флага -atolserver
+func runAtolStub() {
+	addr := *atolServer
+	if u, err := url.Parse(*atolServer); err == nil && u.Host != "" {
+		addr = u.Host
+	}
+	stub := &TAtolStub{results: make(map[string]TAtolResult), fd: 1000}
+	logsmap[LOGINFO_WITHSTD].Printf("заглушка веб-сервера атол запущена на %v", addr)
+	if err := http.ListenAndServe(addr, stub); err != nil {
+		logsmap[LOGERROR].Printf("ошибка (%v) работы заглушки веб-сервера атол", err)
+	}
+}
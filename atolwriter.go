@@ -0,0 +1,72 @@
+package main
+
+//writer json заданий драйвера атол (ДТО 10)
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+type TAtolWriter struct{}
+
+func (TAtolWriter) FileExt() string {
+	return "json"
+}
+
+func (TAtolWriter) Marshal(checkCorr TCorrection) ([]byte, error) {
+	return json.MarshalIndent(getAtolCheckOfCorrection(checkCorr), "", "\t")
+}
+
+// getAtolCheckOfCorrection - задание чека коррекции драйвера атол по модели чека
+func getAtolCheckOfCorrection(checkCorr TCorrection) TCorrectionCheck {
+	var res TCorrectionCheck
+	res.Type = checkCorr.Type
+	res.Electronically = checkCorr.Electronically
+	res.TaxationType = checkCorr.TaxationType
+	res.ClientInfo = checkCorr.ClientInfo
+	res.CorrectionType = checkCorr.CorrectionType
+	res.CorrectionBaseDate = checkCorr.CorrectionBaseDate
+	res.CorrectionBaseNumber = checkCorr.CorrectionBaseNumber
+	res.Operator = checkCorr.Operator
+	if checkCorr.AdditionalAttribute != "" {
+		res.Items = append(res.Items, TTag1192_91{Type: "additionalAttribute", Value: checkCorr.AdditionalAttribute, Print: true})
+	}
+	for _, attr := range checkCorr.UserAttributes {
+		res.Items = append(res.Items, TTag1192_91{Type: "userAttribute", Name: attr.Name, Value: attr.Value, Print: true})
+	}
+	for _, pos := range checkCorr.Positions {
+		res.Items = append(res.Items, getAtolPositionOfCorrection(pos))
+	}
+	res.Payments = checkCorr.Payments
+	res.Total = checkCorr.Total
+	res.IndustryInfo = checkCorr.IndustryInfo
+	for _, tax := range checkCorr.Taxes {
+		res.Taxes = append(res.Taxes, TAtolTaxSum{Type: tax.Type, Sum: tax.Sum})
+	}
+	return res
+}
+
+func getAtolPositionOfCorrection(pos TCorrectionPosition) TPosition {
+	newPos := TPosition{Type: "position"}
+	newPos.Name = pos.Name
+	newPos.Price = pos.Price
+	newPos.Quantity = pos.Quantity
+	newPos.Amount = pos.Amount
+	newPos.MeasurementUnit = pos.MeasurementUnit
+	newPos.PaymentMethod = pos.PaymentMethod
+	newPos.PaymentObject = pos.PaymentObject
+	newPos.Tax = &TTaxNDS{Type: pos.Tax}
+	newPos.AgentInfo = pos.AgentInfo
+	newPos.SupplierInfo = pos.SupplierInfo
+	newPos.IndustryInfo = pos.IndustryInfo
+	if pos.Mark != "" {
+		if isProductCodeOfMark(pos.MarkType) {
+			newPos.ProductCodes = new(TProductCodesAtol)
+			setMarkInArolDriverCorrenspOFDMark(newPos.ProductCodes, pos.Mark, pos.MarkType)
+		} else {
+			//драйвер атол принимает код маркировки в base64
+			newPos.ImcParams = new(TImcParams)
+			newPos.ImcParams.Imc = base64.StdEncoding.EncodeToString([]byte(pos.Mark))
+		}
+	}
+	return newPos
+}
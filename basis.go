@@ -0,0 +1,180 @@
+package main
+
+//основания коррекции из таблицы [[correctionbasis]] файла init.toml: для чеков ФН и (или) периода дат задаётся
+//тип коррекции (самостоятельно или по предписанию), номер и дата документа основания (теги 1178, 1179).
+//Для чеков, которым не подошла ни одна строка, основание задаётся флагами -prescription и -docnumbprescr
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const CORRECTIONTYPESELF = "self"
+const CORRECTIONTYPEINSTRUCTION = "instruction"
+
+type TCorrectionBasis struct {
+	Descr    string
+	FN       string
+	DateFrom string //ГГГГ.ММ.ДД
+	DateTo   string
+	Type     string //self, instruction
+	Number   string
+	Date     string //дата документа основания ГГГГ.ММ.ДД, пусто - дата исходного чека
+}
+
+var CorrectionBases []TCorrectionBasis
+
+// getDateOfBasis - дата из таблицы оснований в формате ГГГГ.ММ.ДД (в init.toml дата может быть строкой или датой toml)
+func getDateOfBasis(val interface{}) (string, error) {
+	switch date := val.(type) {
+	case nil:
+		return "", nil
+	case time.Time:
+		return date.Format("2006.01.02"), nil
+	}
+	dateStr := strings.TrimSpace(fmt.Sprint(val))
+	if dateStr == "" {
+		return "", nil
+	}
+	for _, layout := range []string{"2006.01.02", "02.01.2006", "2006-01-02"} {
+		if date, err := time.Parse(layout, dateStr); err == nil {
+			return date.Format("2006.01.02"), nil
+		}
+	}
+	return "", fmt.Errorf("неверная дата \"%v\" (нужен формат ГГГГ.ММ.ДД)", dateStr)
+}
+
+// getCorrectionTypeFromStr - тип коррекции (тег 1173) по значению из init.toml
+func getCorrectionTypeFromStr(typeStr string) string {
+	switch strings.ToLower(strings.TrimSpace(typeStr)) {
+	case "", CORRECTIONTYPESELF, "0", "самостоятельно":
+		return CORRECTIONTYPESELF
+	case strings.ToLower(CORRECTIONTYPEINSTRUCTION), "1", "предписание", "по предписанию":
+		return CORRECTIONTYPEINSTRUCTION
+	}
+	return ""
+}
+
+// initCorrectionBases - чтение таблицы [[correctionbasis]] и проверка основания, заданного флагами запуска
+func initCorrectionBases(data map[string]interface{}) error {
+	CorrectionBases = nil
+	if *byPrescription && strings.TrimSpace(*docNumbOfPrescription) == "" {
+		return errors.New("для коррекции по предписанию нужно указать номер предписания (флаг -docnumbprescr)")
+	}
+	basesinit, _ := data["correctionbasis"].([]map[string]interface{})
+	for i, basisinit := range basesinit {
+		var basis TCorrectionBasis
+		var err error
+		basis.Descr = strings.TrimSpace(fmt.Sprint(basisinit["descr"]))
+		if basisinit["descr"] == nil {
+			basis.Descr = fmt.Sprintf("основание №%v", i+1)
+		}
+		if basisinit["fn"] != nil {
+			basis.FN = strings.TrimLeft(strings.TrimSpace(fmt.Sprint(basisinit["fn"])), "0")
+		}
+		if basis.DateFrom, err = getDateOfBasis(basisinit["datefrom"]); err != nil {
+			return fmt.Errorf("%v: datefrom: %v", basis.Descr, err)
+		}
+		if basis.DateTo, err = getDateOfBasis(basisinit["dateto"]); err != nil {
+			return fmt.Errorf("%v: dateto: %v", basis.Descr, err)
+		}
+		if basis.Date, err = getDateOfBasis(basisinit["date"]); err != nil {
+			return fmt.Errorf("%v: date: %v", basis.Descr, err)
+		}
+		if basis.FN == "" && basis.DateFrom == "" && basis.DateTo == "" {
+			return fmt.Errorf("%v: нужно указать ФН (fn) и (или) период дат (datefrom, dateto)", basis.Descr)
+		}
+		if basis.DateFrom != "" && basis.DateTo != "" && basis.DateFrom > basis.DateTo {
+			return fmt.Errorf("%v: начало периода %v позже окончания %v", basis.Descr, basis.DateFrom, basis.DateTo)
+		}
+		typeStr := ""
+		if basisinit["type"] != nil {
+			typeStr = fmt.Sprint(basisinit["type"])
+		}
+		if basis.Type = getCorrectionTypeFromStr(typeStr); basis.Type == "" {
+			return fmt.Errorf("%v: неверный тип коррекции \"%v\" (допустимы %v, %v)", basis.Descr, typeStr, CORRECTIONTYPESELF, CORRECTIONTYPEINSTRUCTION)
+		}
+		if basisinit["number"] != nil {
+			basis.Number = strings.TrimSpace(fmt.Sprint(basisinit["number"]))
+		}
+		if basis.Type == CORRECTIONTYPEINSTRUCTION && (basis.Number == "" || basis.Date == "") {
+			return fmt.Errorf("%v: для коррекции по предписанию нужно указать номер (number) и дату (date) предписания", basis.Descr)
+		}
+		CorrectionBases = append(CorrectionBases, basis)
+	}
+	logginInFile(fmt.Sprintf("прочитано %v оснований коррекции", len(CorrectionBases)))
+	return nil
+}
+
+// matches - подходит ли основание для чека по ФН и дате
+func (basis TCorrectionBasis) matches(headofcheck map[string]string) bool {
+	dateOfCheck := getDateOfCheck(headofcheck)
+	if basis.FN != "" && basis.FN != strings.TrimLeft(strings.TrimSpace(headofcheck[COLFNKKT]), "0") {
+		return false
+	}
+	if basis.DateFrom != "" && dateOfCheck < basis.DateFrom {
+		return false
+	}
+	if basis.DateTo != "" && dateOfCheck > basis.DateTo {
+		return false
+	}
+	return true
+}
+
+// getDateOfCheck - дата исходного чека (ГГГГ.ММ.ДД) без времени
+func getDateOfCheck(headofcheck map[string]string) string {
+	dateOfCheck := headofcheck[COLDATE]
+	if len(dateOfCheck) > 10 {
+		dateOfCheck = dateOfCheck[:10]
+	}
+	return dateOfCheck
+}
+
+// fillCorrectionBasis - тип коррекции и документ основания чека: первая подходящая строка таблицы [[correctionbasis]]
+// или флаги запуска. Дата основания по умолчанию - дата исходного чека
+func fillCorrectionBasis(checkCorr *TCorrection, headofcheck map[string]string) {
+	checkCorr.CorrectionType = CORRECTIONTYPESELF
+	checkCorr.CorrectionBaseDate = getDateOfCheck(headofcheck)
+	checkCorr.CorrectionBaseNumber = ""
+	if *byPrescription {
+		checkCorr.CorrectionType = CORRECTIONTYPEINSTRUCTION
+		checkCorr.CorrectionBaseNumber = *docNumbOfPrescription
+	}
+	for _, basis := range CorrectionBases {
+		if !basis.matches(headofcheck) {
+			continue
+		}
+		checkCorr.CorrectionType = basis.Type
+		checkCorr.CorrectionBaseNumber = basis.Number
+		if basis.Date != "" {
+			checkCorr.CorrectionBaseDate = basis.Date
+		}
+		logsmap[LOGREPORT].Printf("чек %v: основание коррекции \"%v\" (%v, номер %v, дата %v)", checkCorr.Descr, basis.Descr,
+			checkCorr.CorrectionType, checkCorr.CorrectionBaseNumber, checkCorr.CorrectionBaseDate)
+		return
+	}
+}
+
+// checkCorrectionBasis - проверка основания коррекции перед записью задания: дата в формате ГГГГ.ММ.ДД не позже
+// сегодняшнего дня, для коррекции по предписанию - номер предписания, выданного не раньше исходного чека
+func checkCorrectionBasis(checkCorr TCorrection, headofcheck map[string]string) error {
+	dateOfBase, err := time.Parse("2006.01.02", checkCorr.CorrectionBaseDate)
+	if err != nil {
+		return fmt.Errorf("неверная дата документа основания коррекции \"%v\"", checkCorr.CorrectionBaseDate)
+	}
+	if dateOfBase.After(time.Now()) {
+		return fmt.Errorf("дата документа основания коррекции %v позже текущей даты", checkCorr.CorrectionBaseDate)
+	}
+	if checkCorr.CorrectionType != CORRECTIONTYPEINSTRUCTION {
+		return nil
+	}
+	if strings.TrimSpace(checkCorr.CorrectionBaseNumber) == "" {
+		return errors.New("для коррекции по предписанию не указан номер предписания")
+	}
+	dateOfCheck := getDateOfCheck(headofcheck)
+	if checkCorr.CorrectionBaseDate < dateOfCheck {
+		return fmt.Errorf("дата предписания %v раньше даты исходного чека %v", checkCorr.CorrectionBaseDate, dateOfCheck)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+//сведения о покупателе ФФД 1.2: наименование (тег 1227), ИНН (тег 1228), дата рождения (тег 1243), гражданство (тег 1244),
+//код и данные документа, удостоверяющего личность (теги 1245, 1246), адрес (тег 1254), а также телефон или email
+//покупателя (тег 1008) и выбор между печатью чека коррекции и отправкой в электронном виде
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// форматы даты рождения в данных ОФД
+var birthDateLayouts = []string{"02.01.2006", "2006-01-02", "2006.01.02", "02/01/2006", "2006-01-02T15:04:05"}
+
+// гражданство (код ОКСМ) по названию страны
+var citizenshipDict = map[string]string{
+	"россия": "643", "рф": "643", "российская федерация": "643",
+	"беларусь": "112", "белоруссия": "112", "казахстан": "398", "армения": "051", "киргизия": "417", "кыргызстан": "417",
+	"узбекистан": "860", "таджикистан": "762", "азербайджан": "031", "молдова": "498", "украина": "804",
+}
+
+// код вида документа, удостоверяющего личность (тег 1245), по названию
+var identityDocumentsDict = map[string]string{
+	"паспорт": "21", "паспорт рф": "21", "паспорт гражданина рф": "21", "паспорт гражданина российской федерации": "21",
+	"загранпаспорт": "22", "военный билет": "27", "паспорт иностранного гражданина": "31", "вид на жительство": "35",
+}
+
+var regexpCodeOfDigits = regexp.MustCompile(`^\d{1,3}$`)
+
+var regexpEmail = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// getNormalizedContact - телефон или email покупателя (тег 1008): email приводится к нижнему регистру, телефон - к +7XXXXXXXXXX
+func getNormalizedContact(contact string) (string, error) {
+	contact = strings.TrimSpace(contact)
+	if strings.Contains(contact, "@") {
+		if !regexpEmail.MatchString(contact) {
+			return "", fmt.Errorf("неверный формат email \"%v\"", contact)
+		}
+		return strings.ToLower(contact), nil
+	}
+	return getNormalizedPhone(contact)
+}
+
+// fillClientContact - телефон или email покупателя и признак отправки чека в электронном виде. Контакт из данных ОФД
+// (поле contactclient) имеет приоритет над email, введённым при запуске (или заданным в файле исправлений).
+// Печатать ли чек, определяется флагом -print, а для отдельного чека - полем printcheck; чек без контакта печатается
+func fillClientContact(checkCorr *TCorrection, headofcheck map[string]string, strInfoAboutCheck string) error {
+	if contact := strings.TrimSpace(headofcheck[COLCONTACTCLIENT]); contact != "" {
+		normContact, err := getNormalizedContact(contact)
+		if err == nil {
+			checkCorr.ClientInfo.EmailOrPhone = normContact
+		} else {
+			logsmap[LOGREPORT].Printf("чек %v: контакт покупателя (тег 1008) из данных ОФД не используется: %v", strInfoAboutCheck, err)
+		}
+	}
+	if checkCorr.ClientInfo.EmailOrPhone == "" && strings.TrimSpace(headofcheck[EMAILFIELD]) != "" {
+		normContact, err := getNormalizedContact(headofcheck[EMAILFIELD])
+		if err != nil {
+			return fmt.Errorf("контакт покупателя (тег 1008): %v", err)
+		}
+		checkCorr.ClientInfo.EmailOrPhone = normContact
+	}
+	checkCorr.Electronically, _ = strconv.ParseBool(headofcheck[NOPRINTFIELD])
+	if printCheck := strings.TrimSpace(headofcheck[COLPRINTCHECK]); printCheck != "" {
+		printOnPaper, err := getBoolFromString(printCheck, !checkCorr.Electronically)
+		if err != nil {
+			logsmap[LOGREPORT].Printf("чек %v: не удалось определить признак печати \"%v\", используется значение флага -print", strInfoAboutCheck, printCheck)
+		}
+		checkCorr.Electronically = !printOnPaper
+	}
+	if checkCorr.Electronically && checkCorr.ClientInfo.EmailOrPhone == "" {
+		logsmap[LOGREPORT].Printf("чек %v: нет телефона или email покупателя, чек коррекции будет напечатан", strInfoAboutCheck)
+		checkCorr.Electronically = false
+	}
+	return nil
+}
+
+// getBirthDateOfClient - дата рождения покупателя в формате ДД.ММ.ГГГГ
+func getBirthDateOfClient(birthDate string) (string, error) {
+	for _, layout := range birthDateLayouts {
+		if date, err := time.Parse(layout, birthDate); err == nil {
+			return date.Format("02.01.2006"), nil
+		}
+	}
+	return "", fmt.Errorf("неверная дата рождения покупателя (тег 1243) \"%v\"", birthDate)
+}
+
+// getCodeOfClient - числовой код из справочника по значению из данных ОФД: код (дополняется нулями слева до длины)
+// или название из справочника
+func getCodeOfClient(val string, dict map[string]string, length int, descr string) (string, error) {
+	valClean := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(val)), "ё", "е")
+	if regexpCodeOfDigits.MatchString(valClean) && len(valClean) <= length {
+		return strings.Repeat("0", length-len(valClean)) + valClean, nil
+	}
+	if code, ok := dict[valClean]; ok {
+		return code, nil
+	}
+	return "", fmt.Errorf("не удалось определить %v \"%v\"", descr, val)
+}
+
+// fillClientInfo - сведения о покупателе из шапки чека. Наименование покупателя без ИНН записывается в отчёт:
+// для организаций и ИП ИНН обязателен, а для физлица нужны данные документа (теги 1243-1246)
+func fillClientInfo(clientInfo *TClientInfo, headofcheck map[string]string, strInfoAboutCheck string) error {
+	var err error
+	clientInfo.Vatin = strings.TrimSpace(headofcheck[COLINNCLIENT])
+	clientInfo.Name = strings.TrimSpace(headofcheck[COLNAMECLIENT])
+	if clientInfo.Vatin != "" && !regexpINN.MatchString(clientInfo.Vatin) {
+		return fmt.Errorf("неверный ИНН покупателя (тег 1228) \"%v\"", clientInfo.Vatin)
+	}
+	if birthDate := strings.TrimSpace(headofcheck[COLBIRTHDATECLIENT]); birthDate != "" {
+		if clientInfo.BirthDate, err = getBirthDateOfClient(birthDate); err != nil {
+			return err
+		}
+	}
+	if citizenship := headofcheck[COLCITIZENSHIPCLIENT]; strings.TrimSpace(citizenship) != "" {
+		if clientInfo.Citizenship, err = getCodeOfClient(citizenship, citizenshipDict, 3, "гражданство покупателя (тег 1244)"); err != nil {
+			return err
+		}
+	}
+	if docCode := headofcheck[COLDOCCODECLIENT]; strings.TrimSpace(docCode) != "" {
+		if clientInfo.IdentityDocumentCode, err = getCodeOfClient(docCode, identityDocumentsDict, 2, "код документа покупателя (тег 1245)"); err != nil {
+			return err
+		}
+	}
+	clientInfo.IdentityDocumentData = strings.TrimSpace(headofcheck[COLDOCDATACLIENT])
+	clientInfo.Address = strings.TrimSpace(headofcheck[COLADDRESSCLIENT])
+	if clientInfo.Name != "" && clientInfo.Vatin == "" {
+		descr := "ИНН покупателя (тег 1228) не указан - для организации и ИП он обязателен"
+		if clientInfo.IdentityDocumentCode == "" || clientInfo.IdentityDocumentData == "" {
+			descr += ", для физлица не указаны данные документа (теги 1245, 1246)"
+		}
+		logsmap[LOGREPORT].Printf("чек %v: у покупателя \"%v\" (тег 1227) %v", strInfoAboutCheck, clientInfo.Name, descr)
+	}
+	return nil
+}
+
+// isEmptyClientInfo - сведений о покупателе нет
+func isEmptyClientInfo(client TClientInfo) bool {
+	return client == TClientInfo{}
+}
@@ -0,0 +1,147 @@
+package main
+
+//кассир чека коррекции (теги 1021, 1203): значение ячейки кассира разбирается на ФИО и ИНН ("Иванов И.И., ИНН 770000000000"),
+//ФИО заменяется полным из справочника infiles/cashiers.csv, а для чеков без кассира (или с системным пользователем)
+//берётся кассир по умолчанию ФН из раздела [operator.fn] файла init.toml. С флагом -currentoperator всем чекам ставится
+//текущий ответственный кассир
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const FILECASHIERS = "cashiers.csv"
+
+// справочник кассиров по нормализованному имени
+var CashiersDict map[string]TOperator
+
+// кассиры по умолчанию по номеру ФН
+var OperatorsOfFN map[string]TOperator
+
+// имена системных пользователей, которые не могут быть кассиром чека коррекции (нормализованные)
+var systemCashiers = map[string]bool{
+	"": true, "администратор": true, "admin": true, "administrator": true, "system": true, "система": true,
+	"кассир": true, "оператор": true, "пользователь": true, "user": true, "-": true,
+}
+
+var regexpINNOfCashier = regexp.MustCompile(`(?i)[,;(]?\s*инн[\s:№]*(\d{12}|\d{10})\)?`)
+var regexpINNOfPerson = regexp.MustCompile(`^\d{12}$`)
+
+// getNormalizedNameOfCashier - имя кассира для поиска в справочнике: без регистра, пробелов и точек, ё заменена на е
+func getNormalizedNameOfCashier(name string) string {
+	res := strings.ReplaceAll(strings.ToLower(name), "ё", "е")
+	for _, r := range []string{" ", ".", " "} {
+		res = strings.ReplaceAll(res, r, "")
+	}
+	return res
+}
+
+// parseCashier - ФИО и ИНН кассира из значения ячейки: "Иванов И.И., ИНН 770000000000", "Иванов И.И. (ИНН 770000000000)"
+func parseCashier(cell string) TOperator {
+	var res TOperator
+	if parts := regexpINNOfCashier.FindStringSubmatch(cell); parts != nil {
+		res.Vatin = parts[1]
+		cell = strings.Replace(cell, parts[0], "", 1)
+	}
+	res.Name = strings.Trim(strings.TrimSpace(cell), ",;")
+	res.Name = strings.TrimSpace(res.Name)
+	return res
+}
+
+// initCashiers - чтение справочника кассиров (name;fullname;inn) и кассиров по умолчанию из раздела [operator.fn]
+func initCashiers(data map[string]interface{}) error {
+	CashiersDict = make(map[string]TOperator)
+	OperatorsOfFN = make(map[string]TOperator)
+	fullnameoffile := DIRINFILES + FILECASHIERS
+	if existfile, _ := doesFileExist(fullnameoffile); existfile {
+		f, err := os.Open(fullnameoffile)
+		if err != nil {
+			return fmt.Errorf("не удалось (%v) открыть файл %v", err, fullnameoffile)
+		}
+		defer f.Close()
+		csv_red := csv.NewReader(f)
+		csv_red.FieldsPerRecord = -1
+		csv_red.LazyQuotes = true
+		csv_red.Comma = ';'
+		lines, err := csv_red.ReadAll()
+		if err != nil {
+			return fmt.Errorf("не удалось (%v) прочитать файл %v", err, fullnameoffile)
+		}
+		for numLine, line := range lines {
+			if numLine == 0 || strings.TrimSpace(strings.Join(line, "")) == "" {
+				continue
+			}
+			if len(line) < 2 {
+				return fmt.Errorf("в строке %v файла %v должно быть не меньше 2 колонок: name;fullname;inn", numLine+1, fullnameoffile)
+			}
+			cashier := TOperator{Name: strings.TrimSpace(line[1])}
+			if len(line) > 2 {
+				cashier.Vatin = strings.TrimSpace(line[2])
+			}
+			if cashier.Name == "" {
+				cashier.Name = strings.TrimSpace(line[0])
+			}
+			if cashier.Vatin != "" && !regexpINNOfPerson.MatchString(cashier.Vatin) {
+				return fmt.Errorf("неверный ИНН кассира \"%v\" в строке %v файла %v", cashier.Vatin, numLine+1, fullnameoffile)
+			}
+			CashiersDict[getNormalizedNameOfCashier(line[0])] = cashier
+		}
+	}
+	operatorinit, _ := data["operator"].(map[string]interface{})
+	if operatorfn, ok := operatorinit["fn"].(map[string]interface{}); ok {
+		for k, v := range operatorfn {
+			cashier := parseCashier(fmt.Sprint(v))
+			if cashier.Name == "" || (cashier.Vatin != "" && !regexpINNOfPerson.MatchString(cashier.Vatin)) {
+				return fmt.Errorf("неверный кассир по умолчанию \"%v\" для ФН %v в разделе [operator.fn]", v, k)
+			}
+			OperatorsOfFN[strings.TrimLeft(strings.TrimSpace(k), "0")] = cashier
+		}
+	}
+	if *currentOperatorINN != "" && !regexpINNOfPerson.MatchString(strings.TrimSpace(*currentOperatorINN)) {
+		return fmt.Errorf("неверный ИНН текущего кассира -currentoperatorinn %v", *currentOperatorINN)
+	}
+	if *currentOperatorINN != "" && strings.TrimSpace(*currentOperator) == "" {
+		return errors.New("ИНН текущего кассира -currentoperatorinn указан без ФИО -currentoperator")
+	}
+	logginInFile(fmt.Sprintf("прочитано %v кассиров справочника и %v кассиров по умолчанию для ФН", len(CashiersDict), len(OperatorsOfFN)))
+	return nil
+}
+
+// getCashierOfDict - кассир с ФИО и ИНН из справочника (ИНН из данных имеет приоритет)
+func getCashierOfDict(cashier TOperator) TOperator {
+	if cashierOfDict, ok := CashiersDict[getNormalizedNameOfCashier(cashier.Name)]; ok {
+		cashier.Name = cashierOfDict.Name
+		if cashier.Vatin == "" {
+			cashier.Vatin = cashierOfDict.Vatin
+		}
+	}
+	return cashier
+}
+
+// getOperatorOfCheck - кассир чека коррекции: текущий ответственный (флаг -currentoperator), кассир из данных ОФД
+// или кассир по умолчанию ФН. Ошибка - для чека нет кассира
+func getOperatorOfCheck(headofcheck map[string]string, strInfoAboutCheck string) (TOperator, error) {
+	if strings.TrimSpace(*currentOperator) != "" {
+		return getCashierOfDict(TOperator{Name: strings.TrimSpace(*currentOperator), Vatin: strings.TrimSpace(*currentOperatorINN)}), nil
+	}
+	cashier := parseCashier(headofcheck[COLKASSIR])
+	if innkassir := strings.TrimSpace(headofcheck[COLINNKASSIR]); innkassir != "" {
+		cashier.Vatin = innkassir
+	}
+	if cashier.Vatin != "" && !regexpINNOfPerson.MatchString(cashier.Vatin) {
+		logsmap[LOGREPORT].Printf("чек %v: неверный ИНН кассира \"%v\" не используется", strInfoAboutCheck, cashier.Vatin)
+		cashier.Vatin = ""
+	}
+	if !systemCashiers[getNormalizedNameOfCashier(cashier.Name)] {
+		return getCashierOfDict(cashier), nil
+	}
+	if cashierOfFN, ok := OperatorsOfFN[strings.TrimLeft(strings.TrimSpace(headofcheck[COLFNKKT]), "0")]; ok {
+		logsmap[LOGREPORT].Printf("чек %v: нет кассира в данных ОФД (\"%v\"), используется кассир по умолчанию ФН %v", strInfoAboutCheck, headofcheck[COLKASSIR], cashierOfFN.Name)
+		return getCashierOfDict(cashierOfFN), nil
+	}
+	logsmap[LOGREPORT].Printf("чек %v: нет кассира (\"%v\") - укажите кассира по умолчанию для ФН %v в разделе [operator.fn] файла init.toml или флаг -currentoperator", strInfoAboutCheck, headofcheck[COLKASSIR], headofcheck[COLFNKKT])
+	return TOperator{}, fmt.Errorf("нет кассира для чека (в данных ОФД \"%v\")", headofcheck[COLKASSIR])
+}
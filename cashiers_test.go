@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestParseCashier(t *testing.T) {
+	tests := []struct {
+		cell string
+		want TOperator
+	}{
+		{"Иванов И.И.", TOperator{Name: "Иванов И.И."}},
+		{"Иванов И.И., ИНН 770000000000", TOperator{Name: "Иванов И.И.", Vatin: "770000000000"}},
+		{"Иванов И.И. (ИНН 770000000000)", TOperator{Name: "Иванов И.И.", Vatin: "770000000000"}},
+		{"Иванов И.И.; инн: 770000000000", TOperator{Name: "Иванов И.И.", Vatin: "770000000000"}},
+		{"Иванов И.И. ИНН №7700000000", TOperator{Name: "Иванов И.И.", Vatin: "7700000000"}},
+		{"ИНН 770000000000", TOperator{Vatin: "770000000000"}},
+		{"Иванов И.И., ИНН 77000", TOperator{Name: "Иванов И.И., ИНН 77000"}},
+		{"  Петров П.П.,  ", TOperator{Name: "Петров П.П."}},
+		{"", TOperator{}},
+	}
+	for _, tt := range tests {
+		if got := parseCashier(tt.cell); got != tt.want {
+			t.Errorf("parseCashier(\"%v\") = %+v, ожидалось %+v", tt.cell, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+//справочник товаров infiles/goods.csv: по коду (поле code) или наименованию позиции заполняются предмет расчёта,
+//ставка НДС, единица измерения, данные поставщика и код товара (теги 1162, 1163). Значения справочника ставятся
+//в пустые поля позиции, а поля из списка override раздела [catalog] файла init.toml заменяются всегда.
+//Справочник применяется до правил преобразования и файла исправлений, поэтому они имеют приоритет
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+const FILECATALOG = "goods.csv"
+
+// поля позиции, которые можно задать в справочнике товаров (заголовок файла goods.csv)
+var catalogFields = []string{COLNAME, COLCODE, COLPREDMET, COLSTAVKANDS, COLUNIT, COLINNOFSUPPLIER, COLNAMEOFSUPPLIER,
+	COLTELOFSUPPLIER, COLPRIZAGENTA, COLPRODUCTCODE, COLPRODUCTGROUP}
+
+// товары справочника по коду и по нормализованному наименованию
+var CatalogByCode map[string]map[string]string
+var CatalogByName map[string]map[string]string
+
+// поля, значения которых из справочника заменяют значения из данных ОФД
+var CatalogOverrideFields []string
+
+// getNormalizedNameOfGood - наименование товара для поиска в справочнике: без регистра и лишних пробелов, ё заменена на е
+func getNormalizedNameOfGood(name string) string {
+	return strings.Join(strings.Fields(strings.ReplaceAll(strings.ToLower(name), "ё", "е")), " ")
+}
+
+// getTypeOfProductCode - тип кода товара (тег 1162) по его значению: EAN-8, EAN-13, ITF-14 или иной код
+func getTypeOfProductCode(code string) string {
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return "Undefined"
+		}
+	}
+	switch len(code) {
+	case 8:
+		return "EAN_8"
+	case 13:
+		return "EAN_13"
+	case 14:
+		return "ITF_14"
+	}
+	return "Undefined"
+}
+
+// initCatalog - чтение необязательного справочника товаров (первая строка - имена полей позиции из [fields.positions])
+// и списка заменяемых полей из раздела [catalog]
+func initCatalog(data map[string]interface{}) error {
+	CatalogByCode = make(map[string]map[string]string)
+	CatalogByName = make(map[string]map[string]string)
+	CatalogOverrideFields = nil
+	cataloginit, _ := data["catalog"].(map[string]interface{})
+	overrideinit, _ := cataloginit["override"].([]interface{})
+	for _, field := range overrideinit {
+		fieldStr := strings.TrimSpace(fmt.Sprint(field))
+		if !slices.Contains(catalogFields, fieldStr) || fieldStr == COLNAME || fieldStr == COLCODE {
+			return fmt.Errorf("поле %v нельзя заменять по справочнику товаров (допустимы %v)", fieldStr, strings.Join(catalogFields[2:], ", "))
+		}
+		CatalogOverrideFields = append(CatalogOverrideFields, fieldStr)
+	}
+	fullnameoffile := DIRINFILES + FILECATALOG
+	if existfile, _ := doesFileExist(fullnameoffile); !existfile {
+		return nil
+	}
+	f, err := os.Open(fullnameoffile)
+	if err != nil {
+		return fmt.Errorf("не удалось (%v) открыть файл %v", err, fullnameoffile)
+	}
+	defer f.Close()
+	csv_red := csv.NewReader(f)
+	csv_red.FieldsPerRecord = -1
+	csv_red.LazyQuotes = true
+	csv_red.Comma = ';'
+	lines, err := csv_red.ReadAll()
+	if err != nil {
+		return fmt.Errorf("не удалось (%v) прочитать файл %v", err, fullnameoffile)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	header := make([]string, len(lines[0]))
+	for i, col := range lines[0] {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(col, "\ufeff"))
+		if !slices.Contains(catalogFields, header[i]) {
+			return fmt.Errorf("неизвестное поле \"%v\" в заголовке файла %v (допустимы %v)", col, fullnameoffile, strings.Join(catalogFields, ", "))
+		}
+	}
+	if !slices.Contains(header, COLNAME) && !slices.Contains(header, COLCODE) {
+		return fmt.Errorf("в заголовке файла %v нет ни наименования (%v), ни кода товара (%v)", fullnameoffile, COLNAME, COLCODE)
+	}
+	for numLine, line := range lines[1:] {
+		if strings.TrimSpace(strings.Join(line, "")) == "" {
+			continue
+		}
+		good := make(map[string]string)
+		for i, val := range line {
+			if i < len(header) && strings.TrimSpace(val) != "" {
+				good[header[i]] = strings.TrimSpace(val)
+			}
+		}
+		if good[COLNAME] == "" && good[COLCODE] == "" {
+			return fmt.Errorf("в строке %v файла %v не указаны ни наименование, ни код товара", numLine+2, fullnameoffile)
+		}
+		if good[COLINNOFSUPPLIER] != "" && !regexpINN.MatchString(good[COLINNOFSUPPLIER]) {
+			return fmt.Errorf("неверный ИНН поставщика \"%v\" в строке %v файла %v", good[COLINNOFSUPPLIER], numLine+2, fullnameoffile)
+		}
+		if good[COLSTAVKANDS] != "" && getStavkaNDSFromStr(good[COLSTAVKANDS]) == "" {
+			return fmt.Errorf("неверная ставка НДС \"%v\" в строке %v файла %v", good[COLSTAVKANDS], numLine+2, fullnameoffile)
+		}
+		if code := good[COLCODE]; code != "" {
+			if _, ok := CatalogByCode[code]; ok {
+				logsmap[LOGERROR].Printf("код товара %v повторяется в строке %v файла %v, используется первая строка", code, numLine+2, fullnameoffile)
+			} else {
+				CatalogByCode[code] = good
+			}
+		}
+		if name := getNormalizedNameOfGood(good[COLNAME]); name != "" {
+			if _, ok := CatalogByName[name]; ok {
+				logsmap[LOGERROR].Printf("товар \"%v\" повторяется в строке %v файла %v, используется первая строка", good[COLNAME], numLine+2, fullnameoffile)
+			} else {
+				CatalogByName[name] = good
+			}
+		}
+	}
+	logginInFile(fmt.Sprintf("прочитано %v товаров справочника по коду и %v по наименованию", len(CatalogByCode), len(CatalogByName)))
+	return nil
+}
+
+// getGoodOfCatalog - товар справочника для позиции: сначала по коду, затем по наименованию
+func getGoodOfCatalog(pos map[string]string) (map[string]string, bool) {
+	if code := strings.TrimSpace(pos[COLCODE]); code != "" {
+		if good, ok := CatalogByCode[code]; ok {
+			return good, true
+		}
+	}
+	good, ok := CatalogByName[getNormalizedNameOfGood(pos[COLNAME])]
+	return good, ok
+}
+
+// isEmptyStavkaNDSOfPos - в данных ОФД для позиции нет ни ставки НДС, ни сумм НДС по ставкам
+func isEmptyStavkaNDSOfPos(pos map[string]string) bool {
+	if strings.TrimSpace(pos[COLSTAVKANDS]) != "" {
+		return false
+	}
+	for _, col := range stavkaNDSColumns {
+		if strings.TrimSpace(pos[col]) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// applyCatalog - дополнение позиций чека данными справочника товаров. Изменения каждой позиции записываются в отчёт
+func applyCatalog(poss map[int]map[string]string, checkDescrInfo string) {
+	if len(CatalogByCode) == 0 && len(CatalogByName) == 0 {
+		return
+	}
+	for numPos, pos := range poss {
+		good, ok := getGoodOfCatalog(pos)
+		if !ok {
+			continue
+		}
+		var changes []string
+		for _, field := range catalogFields {
+			val := good[field]
+			if val == "" || field == COLNAME || field == COLCODE {
+				continue
+			}
+			override := slices.Contains(CatalogOverrideFields, field)
+			if field == COLSTAVKANDS {
+				//ставка из справочника заменяет и ставку, определяемую по суммам НДС позиции
+				stavka := getStavkaNDSFromStr(val)
+				stavkaOfPos := pos[FORCEDNDSFIELD]
+				if stavkaOfPos == "" {
+					stavkaOfPos = getDeclaredStavkaNDS(pos)
+				}
+				if override {
+					if stavkaOfPos != stavka {
+						changes = append(changes, fmt.Sprintf("ставка НДС \"%v\" -> \"%v\"", stavkaOfPos, stavka))
+					}
+					pos[FORCEDNDSFIELD] = stavka
+				} else if isEmptyStavkaNDSOfPos(pos) {
+					changes = append(changes, fmt.Sprintf("%v \"\" -> \"%v\"", field, val))
+					pos[field] = val
+				}
+				continue
+			}
+			if (strings.TrimSpace(pos[field]) != "" && !override) || pos[field] == val {
+				continue
+			}
+			changes = append(changes, fmt.Sprintf("%v \"%v\" -> \"%v\"", field, pos[field], val))
+			pos[field] = val
+		}
+		if len(changes) > 0 {
+			logsmap[LOGREPORT].Printf("чек %v: позиция %v \"%v\" дополнена по справочнику товаров: %v", checkDescrInfo, numPos, pos[COLNAME], strings.Join(changes, ", "))
+		}
+	}
+}
@@ -6,7 +6,6 @@ package main
 //checks_other.csv
 import (
 	"bufio"
-	"encoding/base64"
 	"encoding/csv"
 	"encoding/json"
 	"errors"
@@ -18,6 +17,7 @@ import (
 	"math"
 	"net/http"
 	"os"
+	"regexp"
 	"slices"
 	"sort"
 	"strconv"
@@ -35,6 +35,7 @@ const EMAILFIELD = "email"
 const NOPRINTFIELD = "electronically"
 const NAMETYPEOFMARK = "TYPEMARK"
 
+const COLINN = "inn"
 const COLREGNUMKKT = "regnumkkt"
 const COLFNKKT = "fnkkt"
 const COLNAMEOFKKT = "nameofkkt"
@@ -55,6 +56,17 @@ const COLKASSIR = "kassir"
 const COLINNKASSIR = "innkassir"
 const COLNAMECLIENT = "nameclient"
 const COLINNCLIENT = "innclient"
+const COLBIRTHDATECLIENT = "birthdateclient"     //тег 1243
+const COLCITIZENSHIPCLIENT = "citizenshipclient" //тег 1244
+const COLDOCCODECLIENT = "doccodeclient"         //тег 1245
+const COLDOCDATACLIENT = "docdataclient"         //тег 1246
+const COLADDRESSCLIENT = "addressclient"         //тег 1254
+const COLCONTACTCLIENT = "contactclient"         //тег 1008 - телефон или email покупателя из данных ОФД
+const COLPRINTCHECK = "printcheck"               //печатать ли чек коррекции (да/нет), по умолчанию - флаг -print
+const COLINDUSTRYFOISCHECK = "industryfoischeck" //тег 1262 реквизита 1261
+const COLINDUSTRYDATECHECK = "industrydatecheck"
+const COLINDUSTRYNUMBERCHECK = "industrynumbercheck"
+const COLINDUSTRYVALUECHECK = "industryvaluecheck"
 const COLTELKASSIR = "telkassir"
 const COLDATE = "date"
 const COLOSN = "osn"
@@ -74,6 +86,21 @@ const COLPRIZAGENTA = "prizagenta"
 const COLNAMEOFSUPPLIER = "nameofsupl"
 const COLINNOFSUPPLIER = "innofsupl"
 const COLTELOFSUPPLIER = "telofsupl"
+const COLOPERAGENTA = "operagenta"           //тег 1044
+const COLTELAGENTA = "telagenta"             //тег 1073
+const COLTELOFPAYOPER = "telofpayoper"       //тег 1074
+const COLTELOFTRANSOPER = "teloftransoper"   //тег 1075
+const COLNAMEOFTRANSOPER = "nameoftransoper" //тег 1026
+const COLADDROFTRANSOPER = "addroftransoper" //тег 1005
+const COLINNOFTRANSOPER = "innoftransoper"   //тег 1016
+const COLUNIT = "unit"
+const COLCODE = "code"                     //код (артикул) товара для поиска в справочнике товаров goods.csv
+const COLPRODUCTCODE = "productcode"       //код товара (тег 1162, 1163): EAN-8, EAN-13, ITF-14 или иной код, не код маркировки
+const COLPRODUCTGROUP = "productgroup"     //товарная группа для отраслевых реквизитов по умолчанию
+const COLINDUSTRYFOIS = "industryfois"     //тег 1262 реквизита 1260
+const COLINDUSTRYDATE = "industrydate"     //тег 1263
+const COLINDUSTRYNUMBER = "industrynumber" //тег 1264
+const COLINDUSTRYVALUE = "industryvalue"   //тег 1265
 
 const COLSTAVKANDS = "stavkaNDS"
 const COLSTAVKANDS0 = "stavkaNDS0"
@@ -83,6 +110,8 @@ const COLSTAVKANDS10 = "stavkaNDS10"
 const COLSTAVKANDS20 = "stavkaNDS20"
 const COLSTAVKANDS110 = "stavkaNDS110"
 const COLSTAVKANDS120 = "stavkaNDS120"
+const COLSTAVKANDS105 = "stavkaNDS105"
+const COLSTAVKANDS107 = "stavkaNDS107"
 const COLMARK = "mark"
 const COLBINDPOSFIELDKASSA = "bindposfieldkassa"
 const COLBINDPOSFIELDCHECK = "bindposfieldcheck"
@@ -105,6 +134,27 @@ const STAVKANDS10 = "vat10"
 const STAVKANDS20 = "vat20"
 const STAVKANDS110 = "vat110"
 const STAVKANDS120 = "vat120"
+const STAVKANDS105 = "vat105"
+const STAVKANDS107 = "vat107"
+
+// префикс полей позиции и шапки, в которых хранятся суммы НДС из столбцов stavkaNDS*
+const PREFSUMMNDS = "summnds$"
+
+// столбцы сумм НДС в порядке приоритета определения ставки
+var stavkaNDSColumns = []string{COLSTAVKANDS20, COLSTAVKANDS5, COLSTAVKANDS7, COLSTAVKANDS10, COLSTAVKANDS0,
+	COLSTAVKANDS120, COLSTAVKANDS110, COLSTAVKANDS105, COLSTAVKANDS107}
+
+var stavkaNDSOfColumn = map[string]string{
+	COLSTAVKANDS0:   STAVKANDS0,
+	COLSTAVKANDS5:   STAVKANDS5,
+	COLSTAVKANDS7:   STAVKANDS7,
+	COLSTAVKANDS10:  STAVKANDS10,
+	COLSTAVKANDS20:  STAVKANDS20,
+	COLSTAVKANDS110: STAVKANDS110,
+	COLSTAVKANDS120: STAVKANDS120,
+	COLSTAVKANDS105: STAVKANDS105,
+	COLSTAVKANDS107: STAVKANDS107,
+}
 
 //const COLSUMMNDS20 = "summNDS20"
 //const COLSUMMPREPAYPOS = "summPrepay"
@@ -119,6 +169,7 @@ const LOGINFO = "info"
 const LOGINFO_WITHSTD = "info_std"
 const LOGERROR = "error"
 const LOGSKIP_LINES = "lines_skip"
+const LOGREPORT = "report" //отчёт о выполненных программой изменениях данных чеков
 
 // const LOGSKIP_LINES = "skip_line"
 const LOGOTHER = "other"
@@ -131,14 +182,25 @@ const DIROFREQUEST = "./request/"
 const DIROFREQUESTASTRAL = "./request/astral/"
 
 type TClientInfo struct {
-	EmailOrPhone string `json:"emailOrPhone,omitempty"`
-	Vatin        string `json:"vatin,omitempty"`
-	Name         string `json:"name,omitempty"`
+	EmailOrPhone         string `json:"emailOrPhone,omitempty"`
+	Vatin                string `json:"vatin,omitempty"`
+	Name                 string `json:"name,omitempty"`
+	BirthDate            string `json:"birthDate,omitempty"`            //тег 1243, ДД.ММ.ГГГГ
+	Citizenship          string `json:"citizenship,omitempty"`          //тег 1244, код ОКСМ
+	IdentityDocumentCode string `json:"identityDocumentCode,omitempty"` //тег 1245
+	IdentityDocumentData string `json:"identityDocumentData,omitempty"` //тег 1246
+	Address              string `json:"address,omitempty"`              //тег 1254
 }
 
 type TTaxNDS struct {
 	Type string `json:"type,omitempty"`
 }
+
+// сумма по ставке НДС для всего чека
+type TAtolTaxSum struct {
+	Type string  `json:"type"`
+	Sum  float64 `json:"sum"`
+}
 type TProductCodesAtol struct {
 	Undefined    string `json:"undefined,omitempty"` //32 символа только
 	Code_EAN_8   string `json:"ean8,omitempty"`
@@ -179,8 +241,17 @@ type TPosition struct {
 	ProductCodes *TProductCodesAtol `json:"productCodes,omitempty"`
 	ImcParams    *TImcParams        `json:"imcParams,omitempty"`
 	//Mark         string             `json:"mark,omitempty"`
-	AgentInfo    *TAgentInfo    `json:"agentInfo,omitempty"`
-	SupplierInfo *TSupplierInfo `json:"supplierInfo,omitempty"`
+	AgentInfo    *TAgentInfo     `json:"agentInfo,omitempty"`
+	SupplierInfo *TSupplierInfo  `json:"supplierInfo,omitempty"`
+	IndustryInfo []TIndustryInfo `json:"industryInfo,omitempty"`
+}
+
+// отраслевой реквизит (теги 1260 и 1261)
+type TIndustryInfo struct {
+	Fois              string `json:"fois"`              //тег 1262 - идентификатор ФОИВ
+	Date              string `json:"date"`              //тег 1263 - дата документа основания ДД.ММ.ГГГГ
+	Number            string `json:"number"`            //тег 1264 - номер документа основания
+	IndustryAttribute string `json:"industryAttribute"` //тег 1265 - значение отраслевого реквизита
 }
 
 type TTag1192_91 struct {
@@ -196,7 +267,23 @@ type TOperator struct {
 }
 
 type TAgentInfo struct {
-	Agents []string `json:"agents"`
+	Agents                  []string                  `json:"agents"`
+	PayingAgent             *TPayingAgent             `json:"payingAgent,omitempty"`
+	ReceivePaymentsOperator *TReceivePaymentsOperator `json:"receivePaymentsOperator,omitempty"`
+	MoneyTransferOperator   *TMoneyTransferOperator   `json:"moneyTransferOperator,omitempty"`
+}
+type TPayingAgent struct {
+	Operation string   `json:"operation,omitempty"` //тег 1044
+	Phones    []string `json:"phones,omitempty"`    //тег 1073
+}
+type TReceivePaymentsOperator struct {
+	Phones []string `json:"phones,omitempty"` //тег 1074
+}
+type TMoneyTransferOperator struct {
+	Phones  []string `json:"phones,omitempty"`  //тег 1075
+	Name    string   `json:"name,omitempty"`    //тег 1026
+	Address string   `json:"address,omitempty"` //тег 1005
+	Vatin   string   `json:"vatin,omitempty"`   //тег 1016
 }
 type TSupplierInfo struct {
 	Vatin  string   `json:"vatin"`
@@ -220,9 +307,11 @@ type TCorrectionCheck struct {
 	CorrectionBaseNumber string      `json:"correctionBaseNumber"`
 	Operator             TOperator   `json:"operator"`
 	//Items                []TPosition `json:"items"`
-	Items    []interface{} `json:"items"`
-	Payments []TPayment    `json:"payments"`
-	Total    float64       `json:"total,omitempty"`
+	Items        []interface{}   `json:"items"`
+	Payments     []TPayment      `json:"payments"`
+	Total        float64         `json:"total,omitempty"`
+	Taxes        []TAtolTaxSum   `json:"taxes,omitempty"`
+	IndustryInfo []TIndustryInfo `json:"industryInfo,omitempty"`
 }
 
 // json чека в ОФД.RU
@@ -236,6 +325,7 @@ type TReceiptOFD struct {
 		Amount_Advance  int64      `json:"Amount_Advance"`
 		Amount_Loan     int64      `json:"Amount_Loan"`
 		Amount_Granting int64      `json:"Amount_Granting"`
+		TaxationType    int        `json:"TaxationType"`
 		Items           []TItemOFD `json:"Items"`
 		// other fields
 	} `json:"Document"`
@@ -300,31 +390,48 @@ type TImcParams struct {
 var clearLogsProgramm = flag.Bool("clearlogs", true, "очистить логи программы")
 
 var ofdchoice = flag.Int("ofd", 0, "Порядковый номер ОФД в файле настроек init.toml раздел [template.ofd]")
-var email = flag.String("email", "", "email, на которое будут отсылаться все чеки")
-var printonpaper = flag.Bool("print", true, "печатать на бумагу (true) или не печатать (false) чек коорекции")
+var email = flag.String("email", "", "email или телефон, на который будут отсылаться чеки без телефона или email покупателя в данных ОФД")
+var printonpaper = flag.Bool("print", true, "печатать на бумагу (true) или не печатать (false) чек коорекции, если для чека не указано поле printcheck")
 var debug = flag.Bool("debug", false, "режим отладки")
 var fetchalways = flag.Bool("fetchalways", true, "всегда посылать запросы по ссылке, не зависимо от предмета расчета")
 var byPrescription = flag.Bool("prescription", false, "по предписанию (true) или самостоятельно (false)")
 var docNumbOfPrescription = flag.String("docnumbprescr", "", "номер документа предписания налоговой")
-var measurementUnitOfFracQuantSimple = flag.String("fracquantunitsimple", "кг", "мера измерения дробного количества товара без макри (кг, л, грамм, иная)")
-var measurementUnitOfFracQuantMark = flag.String("fracquantunitmark", "кг", "мера измерения дробного количества товара с маркой (кг, л, грамм, иная)")
+var currentOperator = flag.String("currentoperator", "", "ФИО текущего ответственного кассира для всех чеков коррекции (вместо кассира исходного чека)")
+var currentOperatorINN = flag.String("currentoperatorinn", "", "ИНН текущего ответственного кассира (флаг -currentoperator)")
+var measurementUnitOfFracQuantSimple = flag.String("fracquantunitsimple", "кг", "мера измерения дробного количества товара без макри (кг, л, грамм, иная), если единица не указана в данных ОФД")
+var measurementUnitOfFracQuantMark = flag.String("fracquantunitmark", "кг", "мера измерения дробного количества товара с маркой (кг, л, грамм, иная), если единица не указана в данных ОФД")
 var checkdoublepos = flag.Bool("checkdoule", false, "проверять на задвоение позиции")
 var reverseoper = flag.Bool("reverse", false, "сделать операцию обратной оперцаии чека (приход станет возратом и наоборот)")
-var propsukatByCondition = flag.Bool("propsukatbycondition", false, "пропускать по условию, жёстко прописанному в коде, для некоторых случваев")
-var changeNDSCustom = flag.Bool("changendscustom", false, "менять НДС кастомно - прописано в коде как")
-var changeSNOCustom = flag.Bool("changensnocustom", false, "менять СНО - прописано в коде как")
+var propsukatByCondition = flag.Bool("propsukatbycondition", false, "пропускать чеки без суммы НДС 5% (правило добавляется к правилам [[rules]] из init.toml); с этим флагом чеки, принятые ФНС, не пропускаются")
+var changeNDSCustom = flag.Bool("changendscustom", false, "менять ставку НДС всех позиций на \"без НДС\" (правило добавляется к правилам [[rules]] из init.toml)")
+var changeSNOCustom = flag.Bool("changensnocustom", false, "менять СНО на значение custom из раздела [sno] файла init.toml (по умолчанию usnIncomeOutcome)")
+var prepaymentRaschRates = flag.Bool("prepaymentrates", true, "менять ставку НДС на расчётную (20/120, 10/110, 5/105, 7/107) для позиций с предоплатой и авансом")
+var paymentStrategy = flag.String("paymentstrategy", PAYMENTSTRATEGYQUARANTINE, "что делать с чеком, у которого суммы оплат не соответствуют сумме чека: cash/card - остаток в наличные/безналичные, proportional - пропорционально изменить все оплаты, head - взять оплаты из шапки чека, positions - взять оплаты из позиций (если их сумма не равна сумме чека, чек пропускается), quarantine - пропустить чек, interactive - ввести суммы вручную")
+var paymentTolerance = flag.Float64("paymenttolerance", 0.01, "допустимое расхождение суммы оплат и суммы чека (в рублях)")
+var reissue = flag.Bool("reissue", false, "для каждого чека формировать два задания: обратную коррекцию исходного чека и прямую коррекцию с исправлениями из правил и файлов исправлений")
+var printPlan = flag.Bool("printplan", false, "формировать план печати (папка plan в папке ФН): открытие и закрытие смен, очистка таблицы проверки марок, чеки и manifest.json")
+var maxChecksInShift = flag.Int("maxchecksinshift", 0, "наибольшее число чеков в одной смене плана печати (0 - без ограничения, кроме 24 часов смены)")
+var secondsPerCheck = flag.Int("secondspercheck", 30, "оценка времени печати одного чека в секундах для соблюдения ограничения смены 24 часами")
+var outFormat = flag.String("outformat", OUTFORMATATOL, "формат заданий чеков коррекции: atol - json задания драйвера атол, shtrih - экспериментальные xml задания драйвера Штрих-М (разметка не документирована драйвером), atolonline - документы облачной кассы АТОЛ Онлайн, atol105 - задания атол для чека коррекции ФФД 1.05 без позиций (для отдельных ФН задаётся в разделе [output.fn] файла init.toml)")
+var command = flag.String("command", "", "команда: пусто - формирование json заданий, submit - отправка заданий на веб-сервер атол, atolstub - запуск заглушки веб-сервера атол, reconcile - сверка напечатанных чеков коррекции с исходными чеками, onlinesubmit - отправка документов в АТОЛ Онлайн, onlinestub - запуск заглушки АТОЛ Онлайн")
+var reconcileSource = flag.String("reconcilesource", RECONCILESOURCEJOURNAL, "источник данных о напечатанных чеках коррекции для команды reconcile: journal - журналы printed.txt, export - выгрузка ОФД infiles/corrections.csv (шаблон из -ofd)")
+var atolServer = flag.String("atolserver", "http://localhost:16732", "адрес веб-сервера драйвера атол (для отдельного ФН можно указать в файле connection.txt папки ФН)")
+var submitTimeout = flag.Int("submittimeout", 120, "сколько секунд ждать выполнения задания веб-сервером атол")
 var addOsnovaniyIfExist = flag.Bool("addosnovisexist", false, "добавлять основание самого первого чека если оно существует")
 
 var FieldsNums map[string]int
 var FieldsNames map[string]string
 var OFD string
-var AllFieldsUnionOfCheck []string
 var AllFieldsHeadOfCheck []string
 var AllFieldPositionsOfCheck []string
 var AllFieldOtherOfCheck []string
 
 var glDelimitter rune
 
+var SnoOverridesFN map[string]string
+var SnoOverridesINN map[string]string
+var SnoCustom = "usnIncomeOutcome"
+
 // var emulation = flag.Bool("emul", false, "эмуляция")
 func main() {
 	var data map[string]interface{}
@@ -359,6 +466,27 @@ func main() {
 	}
 	logginInFile(runDescription)
 	fmt.Println("debug: ", *debug)
+	switch *command {
+	case "":
+	case COMMANDSUBMIT:
+		runSubmit()
+		return
+	case COMMANDATOLSTUB:
+		runAtolStub()
+		return
+	case COMMANDRECONCILE:
+		//для выгрузки ОФД сверка выполняется после выбора шаблона ОФД
+		if *reconcileSource != RECONCILESOURCEEXPORT {
+			runReconcile()
+			return
+		}
+	case COMMANDATOLONLINESUBMIT, COMMANDATOLONLINESTUB:
+		//команды АТОЛ Онлайн выполняются после чтения раздела [atolonline] файла настроек
+	default:
+		descrError := fmt.Sprintf("неизвестная команда %v", *command)
+		logsmap[LOGERROR].Println(descrError)
+		log.Panic(descrError)
+	}
 	//определение параметров запуска
 	//читаем файл настроек
 	if _, err := toml.DecodeFile("init.toml", &data); err != nil {
@@ -368,6 +496,18 @@ func main() {
 		input.Scan()
 		log.Panic(err)
 	}
+	//читаем таблицу переопределения системы налогообложения
+	initSnoOverrides(data)
+	switch *command {
+	case COMMANDATOLONLINESUBMIT:
+		initAtolOnline(data)
+		runAtolOnlineSubmit()
+		return
+	case COMMANDATOLONLINESTUB:
+		initAtolOnline(data)
+		runAtolOnlineStub()
+		return
+	}
 	//читаем все доступные ОФД
 	ofdsinit = make(map[string]string)
 	ofdarray = make(map[int]string)
@@ -415,12 +555,29 @@ func main() {
 		log.Panic(descrError)
 	}
 	fmt.Println(ofdsinit[OFD])
+	if *command == COMMANDRECONCILE {
+		initFieldsOfTemplate(data)
+		runReconcile()
+		return
+	}
 	if *email == "" {
-		fmt.Print("Введите email, на которое будут отсылаться все чеки: ")
+		fmt.Print("Введите email или телефон, на который будут отсылаться чеки без контакта покупателя в данных ОФД: ")
 		input := bufio.NewScanner(os.Stdin)
 		input.Scan()
 		*email = input.Text()
 	}
+	if *email != "" {
+		normEmail, err := getNormalizedContact(*email)
+		if err != nil {
+			descrError := fmt.Sprintf("ошибка (%v) в email или телефоне для отправки чеков", err)
+			logsmap[LOGERROR].Println(descrError)
+			fmt.Println("Нажмите любую клавишу...")
+			input := bufio.NewScanner(os.Stdin)
+			input.Scan()
+			log.Panic(descrError)
+		}
+		*email = normEmail
+	}
 	if (*email != "") && (*printonpaper) {
 		fmt.Println("printonpaper", *printonpaper)
 		fmt.Print("Печать чеки на бумаге (да/нет, по умолчание да) :")
@@ -428,9 +585,6 @@ func main() {
 		input.Scan()
 		*printonpaper, _ = getBoolFromString(input.Text(), *printonpaper)
 	}
-	if *email == "" {
-		*printonpaper = true
-	}
 	if OFD == "ofdru" {
 		fmt.Print("Всегда посылать запросы по ссылке, не зависимо от предмета расчета (да/нет, по умолчанию (да)):")
 		input := bufio.NewScanner(os.Stdin)
@@ -447,14 +601,14 @@ func main() {
 		input.Scan()
 		*docNumbOfPrescription = input.Text()
 	}
-	fmt.Print("Мера измерения дробного количества товара без марки (кг, л, грамм, иная, по умолчанию кг):")
+	fmt.Print("Мера измерения дробного количества товара без марки, если она не указана в данных ОФД (кг, л, грамм, иная, по умолчанию кг):")
 	input = bufio.NewScanner(os.Stdin)
 	input.Scan()
 	*measurementUnitOfFracQuantSimple = input.Text()
 	if *measurementUnitOfFracQuantSimple == "" {
 		*measurementUnitOfFracQuantSimple = "кг"
 	}
-	fmt.Print("Мера измерения дробного количества товара с маркой (кг, л, грамм, иная, по умолчанию кг):")
+	fmt.Print("Мера измерения дробного количества товара с маркой, если она не указана в данных ОФД (кг, л, грамм, иная, по умолчанию кг):")
 	input = bufio.NewScanner(os.Stdin)
 	input.Scan()
 	*measurementUnitOfFracQuantMark = input.Text()
@@ -490,29 +644,14 @@ func main() {
 		*checkdoublepos = true
 	}
 	//инициализация колонок файлов
-	logginInFile("инициализация номеров колонок")
-	FieldsNums = make(map[string]int)
-	FieldsNames = make(map[string]string)
-	for k, v := range data[OFD].(map[string]interface{}) {
-		logginInFile(fmt.Sprintf("k=%v, v=%v", k, v))
-		FieldsNames[k] = fmt.Sprint(v)
-		AllFieldsUnionOfCheck = append(AllFieldsUnionOfCheck, k)
-	}
-	if OFD != "astral_union" {
-		for k := range data["fields"].(map[string]interface{})["kkt"].(map[string]interface{}) {
-			AllFieldsHeadOfCheck = append(AllFieldsHeadOfCheck, k)
-		}
-		for k := range data["fields"].(map[string]interface{})["check"].(map[string]interface{}) {
-			//logginInFile(fmt.Sprintf("check field %v", k))
-			//logginInFile(fmt.Sprintf("check field %v", data["fields"].(map[string]interface{})["check"].(map[string]interface{})[k]))
-			AllFieldsHeadOfCheck = append(AllFieldsHeadOfCheck, k)
-		}
-		for k := range data["fields"].(map[string]interface{})["positions"].(map[string]interface{}) {
-			AllFieldPositionsOfCheck = append(AllFieldPositionsOfCheck, k)
-		}
-		for k := range data["fields"].(map[string]interface{})["others"].(map[string]interface{}) {
-			AllFieldOtherOfCheck = append(AllFieldOtherOfCheck, k)
-		}
+	initFieldsOfTemplate(data)
+	if err := initLayout(data); err != nil {
+		descrError := fmt.Sprintf("ошибка настройки вида входных данных шаблона: %v", err)
+		logsmap[LOGERROR].Println(descrError)
+		fmt.Println("Нажмите любую клавишу...")
+		input := bufio.NewScanner(os.Stdin)
+		input.Scan()
+		log.Panic(descrError)
 	}
 	//инициализация директории результатов
 	if foundedLogDir, _ := doesFileExist(JSONRES); !foundedLogDir {
@@ -521,10 +660,7 @@ func main() {
 	logsmap[LOGINFO_WITHSTD].Println("формирование json заданий начато")
 	//инициализация входных данных
 	logginInFile("открытие файла списка чеков")
-	fileofheadername := "checks_header"
-	if OFD == "astral_union" {
-		fileofheadername = "union"
-	}
+	fileofheadername := FlatFileName
 	f, err := os.Open(DIRINFILES + fileofheadername + ".csv")
 	if err != nil {
 		descrError := fmt.Sprintf("не удлаось (%v) открыть файл (%v.csv) входных данных (шапки чека)", err, fileofheadername)
@@ -558,9 +694,6 @@ func main() {
 	//fmt.Printf("dd=%v\n", lines)
 	if len(lines) > 0 {
 		typetanletemp := "head"
-		if OFD == "astral_union" {
-			typetanletemp = "union"
-		}
 		//проверка на пустоту первой строки для такском
 		currNumbLineOfHead := 0
 		logginInFile(fmt.Sprintf("lines[0]=%v", lines[0]))
@@ -585,22 +718,93 @@ func main() {
 		logginInFile(fmt.Sprintf("lines[currNumbLineOfHead]=%v", lines[currNumbLineOfHead]))
 		FieldsNums = getNumberOfFieldsInCSV(lines[currNumbLineOfHead], FieldsNames, FieldsNums, typetanletemp)
 		//logginInFile(fmt.Sprintln("FieldsNums0", FieldsNums))
+		if FlatLayout {
+			//в плоской таблице столбцы позиций находятся в том же файле
+			FieldsNums = getNumberOfFieldsInCSV(lines[currNumbLineOfHead], FieldsNames, FieldsNums, "positions")
+		}
 	}
 	//fillFieldsNumByPositionTable(FieldsNames, FieldsNums, "checks_header.csv", "head")
-	err = fillFieldsNumByPositionTable(FieldsNames, FieldsNums, "checks_poss.csv", "positions")
-	//logginInFile(fmt.Sprintln("FieldsNums01", FieldsNums))
-	if (err != nil) && (OFD != "astral_link") && (OFD != "astral_union") {
-		descrError := fmt.Sprintf("не удлаось (%v) прочитать файл (checks_poss.csv) входных данных (позиции чека)", err)
+	if !FlatLayout {
+		err = fillFieldsNumByPositionTable(FieldsNames, FieldsNums, "checks_poss.csv", "positions")
+		//logginInFile(fmt.Sprintln("FieldsNums01", FieldsNums))
+		if (err != nil) && (OFD != "astral_link") {
+			descrError := fmt.Sprintf("не удлаось (%v) прочитать файл (checks_poss.csv) входных данных (позиции чека)", err)
+			logsmap[LOGERROR].Println(descrError)
+			fmt.Println("Нажмите любую клавишу...")
+			input := bufio.NewScanner(os.Stdin)
+			input.Scan()
+			log.Panic(descrError)
+		}
+	}
+	err = fillFieldsNumByPositionTable(FieldsNames, FieldsNums, "checks_other.csv", "other")
+	if (err != nil) && (OFD != "astral_link") && !FlatLayout {
+		logstr := fmt.Sprintf("не удлаось (%v) прочитать файл (checks_other.csv) входных данных (прочие данные чека(например марик))", err)
+		logginInFile(logstr)
+	}
+	//читаем правила преобразования чеков
+	if err := initRules(data); err != nil {
+		descrError := fmt.Sprintf("ошибка чтения правил преобразования чеков: %v", err)
 		logsmap[LOGERROR].Println(descrError)
 		fmt.Println("Нажмите любую клавишу...")
 		input := bufio.NewScanner(os.Stdin)
 		input.Scan()
 		log.Panic(descrError)
 	}
-	err = fillFieldsNumByPositionTable(FieldsNames, FieldsNums, "checks_other.csv", "other")
-	if (err != nil) && (OFD != "astral_link") && (OFD != "astral_union") {
-		logstr := fmt.Sprintf("не удлаось (%v) прочитать файл (checks_other.csv) входных данных (прочие данные чека(например марик))", err)
-		logginInFile(logstr)
+	if err := initOverrides(); err != nil {
+		descrError := fmt.Sprintf("ошибка чтения файла исправлений чеков: %v", err)
+		logsmap[LOGERROR].Println(descrError)
+		fmt.Println("Нажмите любую клавишу...")
+		input := bufio.NewScanner(os.Stdin)
+		input.Scan()
+		log.Panic(descrError)
+	}
+	if err := initPaymentStrategy(); err != nil {
+		descrError := fmt.Sprintf("ошибка настройки разрешения ошибок в суммах оплат: %v", err)
+		logsmap[LOGERROR].Println(descrError)
+		fmt.Println("Нажмите любую клавишу...")
+		input := bufio.NewScanner(os.Stdin)
+		input.Scan()
+		log.Panic(descrError)
+	}
+	if err := initOutFormats(data); err != nil {
+		descrError := fmt.Sprintf("ошибка настройки формата заданий: %v", err)
+		logsmap[LOGERROR].Println(descrError)
+		fmt.Println("Нажмите любую клавишу...")
+		input := bufio.NewScanner(os.Stdin)
+		input.Scan()
+		log.Panic(descrError)
+	}
+	if err := initCashiers(data); err != nil {
+		descrError := fmt.Sprintf("ошибка чтения справочника кассиров: %v", err)
+		logsmap[LOGERROR].Println(descrError)
+		fmt.Println("Нажмите любую клавишу...")
+		input := bufio.NewScanner(os.Stdin)
+		input.Scan()
+		log.Panic(descrError)
+	}
+	if err := initCatalog(data); err != nil {
+		descrError := fmt.Sprintf("ошибка чтения справочника товаров: %v", err)
+		logsmap[LOGERROR].Println(descrError)
+		fmt.Println("Нажмите любую клавишу...")
+		input := bufio.NewScanner(os.Stdin)
+		input.Scan()
+		log.Panic(descrError)
+	}
+	if err := initCorrectionBases(data); err != nil {
+		descrError := fmt.Sprintf("ошибка чтения оснований коррекции [[correctionbasis]]: %v", err)
+		logsmap[LOGERROR].Println(descrError)
+		fmt.Println("Нажмите любую клавишу...")
+		input := bufio.NewScanner(os.Stdin)
+		input.Scan()
+		log.Panic(descrError)
+	}
+	if err := initIndustryDefaults(data); err != nil {
+		descrError := fmt.Sprintf("ошибка чтения отраслевых реквизитов [[industry]]: %v", err)
+		logsmap[LOGERROR].Println(descrError)
+		fmt.Println("Нажмите любую клавишу...")
+		input := bufio.NewScanner(os.Stdin)
+		input.Scan()
+		log.Panic(descrError)
 	}
 	//fmt.Println("FieldsNames", FieldsNames)
 	//fmt.Println("-------------------")
@@ -609,27 +813,29 @@ func main() {
 	//перебор всех строчек файла с шапкоми чеков
 	countWritedChecks := 0
 	countAllChecks := len(lines) - 1
+	//чеки плоской таблицы по номеру первой строки чека
+	var flatChecks map[int]TFlatCheck
+	if FlatLayout {
+		flatChecks = groupFlatLines(lines, RowOfHeadInHeaderChecks, FieldsNames, FieldsNums)
+		countAllChecks = len(flatChecks)
+	}
 	logsmap[LOGINFO_WITHSTD].Printf("перебор %v чеков", countAllChecks)
 	currLine := 0
-
-	currNumbPos := 0
-	PrevAllFieldsOfCheck := make(map[string]string)
-	resultFindedPositions := make(map[int]map[string]string)
-	if OFD == "astral_union" {
-		lines = append(lines, []string{""})
-	}
 	for _, line := range lines {
 		var summsOfPayment map[string]float64
 		var findedPositions map[int]map[string]string
 		var passedPositions []int
-		fictivnaystr := false
-		currNewCheck := false
 		currLine++
 		if currLine <= RowOfHeadInHeaderChecks {
 			continue //пропускаем настройку названий столбцов
 		}
-		if currLine == RowOfHeadInHeaderChecks {
-			currNewCheck = true
+		flatCheck, firstLineOfCheck := flatChecks[currLine]
+		if FlatLayout {
+			//чек плоской таблицы обрабатывается на его первой строке, остальные строки - его позиции
+			if !firstLineOfCheck {
+				continue
+			}
+			line = flatCheck.Line
 		}
 		regKKT := line[FieldsNums[FieldsNames[COLBINDHEADFIELDKASSA]]]
 		//logginInFile(fmt.Sprintf("regKKT=%v", regKKT))
@@ -644,22 +850,8 @@ func main() {
 			continue
 		}
 
-		//произвольное условие прописанное жёстко в коде для отдельных случаев
-		if *propsukatByCondition {
-			logsmap[LOGINFO].Printf("FieldsNums=%v", FieldsNums)
-			logsmap[LOGINFO].Printf("COLSTAVKANDS5=%v", COLSTAVKANDS5)
-			logsmap[LOGINFO].Printf("FieldsNums[COLSTAVKANDS5]=%v", FieldsNums[COLSTAVKANDS5])
-			logsmap[LOGINFO].Printf("line[FieldsNums[COLSTAVKANDS5]]=%v", line[FieldsNums[COLSTAVKANDS5]])
-			if _, ok := FieldsNums[COLSTAVKANDS5]; ok {
-				if line[FieldsNums[COLSTAVKANDS5]] == "" || line[FieldsNums[COLSTAVKANDS5]] == "0" {
-					logsmap[LOGINFO].Printf("строка №%v пропущена, так сумма НДС 5%% равно \"%v\" нулю", currLine, line[FieldsNums[COLSTAVKANDS5]])
-					logsmap[LOGERROR].Printf("строка №%v пропущена, так сумма НДС 5%% равно \"%v\" нулю", currLine, line[FieldsNums[COLSTAVKANDS5]])
-					continue
-				}
-			}
-		}
-
-		//проверяем статус чека в ФНС
+		//проверяем статус чека в ФНС. С флагом -propsukatbycondition отбор идёт по сумме НДС 5%,
+		//поэтому чеки, принятые ФНС, не пропускаются (так было до переноса условия флага в правила)
 		if num, ok := FieldsNums[COLSTATUSINFNS]; ok && !*propsukatByCondition {
 			if (!strings.Contains(strings.ToUpper(line[num]), strings.ToUpper("Ошибка"))) && (!strings.Contains(strings.ToUpper(line[num]), strings.ToUpper("ошибки"))) {
 				logsmap[LOGINFO].Printf("строка №%v \"%v\" пропущена, так как чек принят ФНС", currLine, line)
@@ -667,15 +859,6 @@ func main() {
 				continue
 			}
 		}
-		if OFD == "astral_union" {
-			if currLine == len(lines) {
-				fictivnaystr = true
-			}
-		}
-		CurrAllFieldsOfCheck := make(map[string]string)
-		//for k := range CurrAllFieldsOfCheck {
-		//	delete(CurrAllFieldsOfCheck, k)
-		//}
 		descrInfo := fmt.Sprintf("обработка строки %v из %v", currLine-1, countAllChecks)
 		logginInFile(descrInfo)
 		strlog := fmt.Sprintln(line)
@@ -684,73 +867,8 @@ func main() {
 		HeadOfCheck := make(map[string]string)
 		HeadOfCheck[EMAILFIELD] = *email
 		HeadOfCheck[NOPRINTFIELD] = fmt.Sprint(!*printonpaper)
-		if OFD == "astral_union" {
-			needGererationJson := false
-			if !fictivnaystr {
-				for _, field := range AllFieldsUnionOfCheck {
-					CurrAllFieldsOfCheck[field] = getfieldval(line, FieldsNums, field)
-				}
-				if CurrAllFieldsOfCheck[COLFD] != PrevAllFieldsOfCheck[COLFD] {
-					currNewCheck = true
-				} else {
-					currNumbPos++
-				}
-			} else {
-				currNewCheck = true
-			}
-			if currNewCheck {
-				if len(resultFindedPositions) > 0 {
-					HeadOfCheck[COLFD] = PrevAllFieldsOfCheck[COLFD]
-					HeadOfCheck[COLFP] = PrevAllFieldsOfCheck[COLFP]
-					HeadOfCheck[COLDATE] = PrevAllFieldsOfCheck[COLDATE]
-					HeadOfCheck[COLTAG1054] = PrevAllFieldsOfCheck[COLTAG1054]
-					HeadOfCheck[COLKASSIR] = PrevAllFieldsOfCheck[COLKASSIR]
-					HeadOfCheck[COLNAMECLIENT] = PrevAllFieldsOfCheck[COLNAMECLIENT]
-					HeadOfCheck[COLINNCLIENT] = PrevAllFieldsOfCheck[COLINNCLIENT]
-					HeadOfCheck[COLAMOUNTCHECK] = PrevAllFieldsOfCheck[COLAMOUNTCHECK]
-					HeadOfCheck[COLNAL] = PrevAllFieldsOfCheck[COLNAL]
-					HeadOfCheck[COLBEZ] = PrevAllFieldsOfCheck[COLBEZ]
-					//for k := range findedPositions {
-					//	for v := range findedPositions[k] {
-					//		delete(findedPositions[k], v)
-					//	}
-					//	delete(findedPositions, k)
-					//}
-					//logsmap[LOGINFO].Println("resultFindedPositions=", resultFindedPositions)
-					findedPositions = make(map[int]map[string]string)
-					for k, v := range resultFindedPositions {
-						findedPositions[k] = make(map[string]string)
-						for kk, vv := range v {
-							findedPositions[k][kk] = vv
-						}
-					}
-					needGererationJson = true
-				}
-				currNumbPos = 1
-				for k := range resultFindedPositions {
-					for v := range resultFindedPositions[k] {
-						delete(resultFindedPositions[k], v)
-					}
-					delete(resultFindedPositions, k)
-				}
-			}
-			//logsmap[LOGINFO].Println("resultFindedPositions0", resultFindedPositions)
-			resultFindedPositions[currNumbPos] = make(map[string]string)
-			for k, v := range CurrAllFieldsOfCheck {
-				//logsmap[LOGINFO].Println("k", k, "v", v)
-				resultFindedPositions[currNumbPos][k] = v
-			}
-			//logsmap[LOGINFO].Println("resultFindedPositions1", resultFindedPositions)
-			//findedPositions[currNumbPos] = AllFieldsOfCheck
-			for k := range PrevAllFieldsOfCheck {
-				delete(PrevAllFieldsOfCheck, k)
-			}
-			for k := range CurrAllFieldsOfCheck {
-				PrevAllFieldsOfCheck[k] = CurrAllFieldsOfCheck[k]
-			}
-			if !needGererationJson {
-				continue
-			}
+		if _, ok := FieldsNums[COLSTAVKANDS5]; ok && *propsukatByCondition {
+			HeadOfCheck[RULESTAVKANDS5OFHEAD] = getfieldval(line, FieldsNums, COLSTAVKANDS5)
 		}
 		for _, field := range AllFieldsHeadOfCheck {
 			//println(FieldsNames[field])
@@ -768,9 +886,13 @@ func main() {
 		for _, field := range AllFieldPositionsOfCheck {
 			if isInvField(FieldsNames[field]) {
 				HeadOfCheck["inv$"+field] = getfieldval(line, FieldsNums, field)
+				if _, ok := stavkaNDSOfColumn[field]; ok {
+					//суммы НДС всего чека остаются в шапке для сверки с позициями
+					HeadOfCheck["inv$"+PREFSUMMNDS+field] = getSummNDSFromLine(line, FieldsNums, field)
+				}
 			}
 		}
-		if (HeadOfCheck[COLBINDHEADFIELDKASSA] == "") && (OFD != "astral_json") && (OFD != "astral_union") {
+		if (HeadOfCheck[COLBINDHEADFIELDKASSA] == "") && (OFD != "astral_json") && !FlatLayout {
 			logsmap[LOGERROR].Printf("HeadOfCheck=%v", HeadOfCheck)
 			logsmap[LOGERROR].Printf("HeadOfCheck[COLBINDHEADFIELDKASSA]=%v", HeadOfCheck[COLBINDHEADFIELDKASSA])
 			//logsmap[LOGERROR].Printf("=%v", HeadOfCheck[COLBINDHEADFIELDKASSA]])
@@ -787,11 +909,13 @@ func main() {
 		valbindcheck := HeadOfCheck[COLBINDHEADDIELDCHECK]
 		//ищем позиции в файле позиций чека, которые бы соответсвовали бы текущеё строке чека //по номеру ФН и названию кассы
 		checkDescrInfo := fmt.Sprintf("(ФД %v (ФП %v) от %v)", HeadOfCheck[COLFD], HeadOfCheck[COLFP], HeadOfCheck[COLDATE])
-		if (OFD != "astral_link") && (OFD != "astral_union") {
+		if FlatLayout {
+			findedPositions, summsOfPayment = flatCheck.Positions, flatCheck.SummsOfPayment
+		} else if OFD != "astral_link" {
 			descrInfo = fmt.Sprintf("для чека %v ищем позиции", checkDescrInfo)
 			logginInFile(descrInfo)
 			findedPositions, summsOfPayment = findPositions(valbindkassa, valbindcheck, FieldsNames, FieldsNums, &passedPositions)
-		} else if OFD != "astral_union" {
+		} else {
 			//findedPositions, summsOfPayment = fillpossitonsbyref(HeadOfCheck, FieldsNames, FieldsNums)
 			descrInfo = fmt.Sprintf("для чека %v получаем позиции get запросом", checkDescrInfo)
 			logginInFile(descrInfo)
@@ -823,9 +947,13 @@ func main() {
 		//декопзируем head and postions
 		//invDecopostions(HeadOfCheck, findedPositions, FieldsNames, FieldsNums)
 		for fieldHead, valFieldHead := range HeadOfCheck {
-			if isInvField(fieldHead) { //переносим его в findedPositions
+			if isInvField(fieldHead) && !strings.Contains(fieldHead, PREFSUMMNDS) { //переносим его в findedPositions
+				fieldnameclear, _ := strings.CutPrefix(fieldHead, "inv$")
+				if _, ok := stavkaNDSOfColumn[fieldnameclear]; ok {
+					//суммы НДС всего чека не являются ставкой каждой позиции, они остаются в шапке
+					continue
+				}
 				for _, pos := range findedPositions {
-					fieldnameclear, _ := strings.CutPrefix(fieldHead, "inv$")
 					pos[fieldnameclear] = valFieldHead
 				}
 			}
@@ -839,32 +967,38 @@ func main() {
 			}
 			break
 		}
-		amountOfCheck := 0.0
-		//logsmap[LOGINFO_WITHSTD].Println("findedPositions=", findedPositions)
-		for _, pos := range findedPositions {
-			amountClean := strings.ReplaceAll(pos[COLAMOUNTPOS], " ", "")
-			spos, errgen := strconv.ParseFloat(amountClean, 64)
-			if errgen != nil {
-				priceClean := strings.ReplaceAll(pos[COLPRICE], " ", "")
-				prloc, errlocpr := strconv.ParseFloat(priceClean, 64)
-				quantityClean := strings.ReplaceAll(pos[COLQUANTITY], " ", "")
-				quloc, errlocqt := strconv.ParseFloat(quantityClean, 64)
-				if (errlocpr != nil) || (errlocqt != nil) {
-					descrErr := fmt.Sprintf("ошибка (%v, %v) парсинга строки (%v, %v) суммы для чека %v", errlocpr, errlocqt, pos[COLPRICE], pos[COLQUANTITY], checkDescrInfo)
-					logsmap[LOGERROR].Println(descrErr)
-				} else {
-					spos = prloc * quloc
-					errgen = nil
-					pos[COLAMOUNTPOS] = fmt.Sprint(spos)
-				}
-			}
-			if errgen != nil {
-				descrErr := fmt.Sprintf("ошибка (%v) парсинга строки %v суммы для чека %v", err, pos[COLAMOUNTPOS], checkDescrInfo)
-				logsmap[LOGERROR].Println(descrErr)
-				continue
-			}
-			amountOfCheck += spos
+		moveSummsNDSOfCheckToHead(HeadOfCheck, findedPositions)
+		if descrErr, ok := fillStavkiNDSBySummsOfCheck(HeadOfCheck, findedPositions, checkDescrInfo); !ok {
+			logsmap[LOGSKIP_LINES].Printf("чек %v помещён в карантин: %v", checkDescrInfo, descrErr)
+			continue
+		}
+		//для режима -reissue сохраняем чек в том виде, в котором он был зарегистрирован
+		var originalHeadOfCheck map[string]string
+		var originalPositions map[int]map[string]string
+		var originalSummsOfPayment map[string]float64
+		if *reissue {
+			originalHeadOfCheck = copyHeadOfCheck(HeadOfCheck)
+			originalPositions = copyPositionsOfCheck(findedPositions)
+			originalSummsOfPayment = copyPayments(summsOfPayment)
+		}
+		//дополняем позиции данными справочника товаров
+		applyCatalog(findedPositions, checkDescrInfo)
+		//применяем правила преобразования чеков из init.toml
+		if applyRules(HeadOfCheck, findedPositions, checkDescrInfo) {
+			logsmap[LOGSKIP_LINES].Printf("чек %v пропущен по правилу преобразования", checkDescrInfo)
+			continue
 		}
+		//применяем ручные исправления чека из файла исправлений
+		paymentsOverridden := applyOverrides(HeadOfCheck, findedPositions, summsOfPayment, checkDescrInfo)
+		countOfPositions = len(findedPositions)
+		amountOfCheck := getAmountOfPositions(findedPositions, checkDescrInfo)
+		//суммы оплат из шапки и из позиций чека - источники для стратегий head и positions
+		paymentsOfHead := getPaymentsOfHead(HeadOfCheck)
+		paymentsOfPoss := make(map[string]float64)
+		for k, v := range summsOfPayment {
+			paymentsOfPoss[k] = v
+		}
+		paymentsOverride, existOverride := getPaymentsOverride(HeadOfCheck[COLFNKKT], HeadOfCheck[COLFD])
 		mistakesInPayment := false
 		if (OFD == "astral_json") || (OFD == "astral_union") {
 			amountClean := strings.ReplaceAll(HeadOfCheck[COLAMOUNTCHECK], " ", "")
@@ -881,122 +1015,61 @@ func main() {
 				continue
 			}
 		}
-		if OFD == "ofdru" && strings.TrimSpace(HeadOfCheck[COLLINK]) != "" {
-			mistakesInPayment = checkMistakeInPayments(amountOfCheck, summsOfPayment)
-			if mistakesInPayment {
-				logginInFile("ошибка в суммах оплат, пытаемся получить данные из ссылки чека")
-				var receipt TReceiptOFD
-				var descrErr string
-				var err error
-				hypperlinkjson := replacefieldbyjsonhrep(HeadOfCheck[COLLINK])
-				//fmt.Println("hypperlinkjson", hypperlinkjson)
-				receipt, descrErr, err = fetchcheck(HeadOfCheck[COLFD], HeadOfCheck[COLFP], hypperlinkjson)
-				analyzeComlite := true
-				if err != nil {
-					logsmap[LOGERROR].Println(descrErr)
-					analyzeComlite = false
-				}
-				if analyzeComlite {
-					summsOfPayment[COLNAL] = float64(receipt.Document.Amount_Cash) / 100
-					summsOfPayment[COLBEZ] = float64(receipt.Document.Amount_ECash) / 100
-					summsOfPayment[COLCREDIT] = float64(receipt.Document.Amount_Loan) / 100
-					summsOfPayment[COLAVANCE] = float64(receipt.Document.Amount_Advance) / 100
-					summsOfPayment[COLVSTRECHPREDST] = float64(receipt.Document.Amount_Granting) / 100
-					mistakesInPayment = checkMistakeInPayments(amountOfCheck, summsOfPayment)
-				}
+		if existOverride {
+			logsmap[LOGREPORT].Printf("чек %v: суммы оплат (%v) заменены на суммы из файла %v (%v)", checkDescrInfo, descrOfPayments(summsOfPayment), FILEPAYMENTSOVERRIDE, descrOfPayments(paymentsOverride))
+			replacePayments(summsOfPayment, paymentsOverride)
+		}
+		//суммы оплат из шапки чека дополняют суммы оплат, собранные по позициям
+		for k, v := range paymentsOfHead {
+			if _, ok := summsOfPayment[k]; !ok {
+				summsOfPayment[k] = v
 			}
-
 		}
-		if mistakesInPayment {
-			deskMistPaym := fmt.Sprintf("Для чека %v не возможно определить сумму оплат. Сделаёте это вручную. И укажите суммы оплат далее...", checkDescrInfo)
-			summPaymentsCurrDescr := fmt.Sprintf("Сейчас суммы оплат такие: наличными %v", summsOfPayment[COLNAL])
-			summPaymentsCurrDescr += fmt.Sprintf(", картой %v", summsOfPayment[COLBEZ])
-			summPaymentsCurrDescr += fmt.Sprintf(", кредитом %v", summsOfPayment[COLCREDIT])
-			summPaymentsCurrDescr += fmt.Sprintf(", дебетом %v", summsOfPayment[COLAVANCE])
-			summPaymentsCurrDescr += fmt.Sprintf(", встречным представлением %v", summsOfPayment[COLVSTRECHPREDST])
-			logginInFile(deskMistPaym)
-			logginInFile(summPaymentsCurrDescr)
-			fmt.Println(deskMistPaym)
-			fmt.Println(summPaymentsCurrDescr)
-			fmt.Printf("Сумма чека %v\n", amountOfCheck)
-
-			fmt.Printf("Введите сумму оплаты наличными (%v):\n", summsOfPayment[COLNAL])
-			input := bufio.NewScanner(os.Stdin)
-			input.Scan()
-			nalch := summsOfPayment[COLNAL]
-			nalstr := input.Text()
-			if notEmptyFloatField(nalstr) {
-				nalClean := strings.ReplaceAll(nalstr, " ", "")
-				nalch, err = strconv.ParseFloat(nalClean, 64)
-				if err != nil {
-					descrErr := fmt.Sprintf("ошибка (%v) парсинга строки %v для налчиного расчёта", err, nalstr)
-					logsmap[LOGERROR].Println(descrErr)
-				}
+		descrMistakesInPayment := ""
+		if existPaymentsInTemplate() {
+			mistakesInPayment, descrMistakesInPayment = checkMistakeInPayments(amountOfCheck, summsOfPayment, findedPositions)
+		}
+		if mistakesInPayment && !existOverride && !paymentsOverridden && OFD == "ofdru" && strings.TrimSpace(HeadOfCheck[COLLINK]) != "" {
+			logginInFile(fmt.Sprintf("ошибка в суммах оплат (%v), пытаемся получить данные из ссылки чека", descrMistakesInPayment))
+			var receipt TReceiptOFD
+			var descrErr string
+			var err error
+			hypperlinkjson := replacefieldbyjsonhrep(HeadOfCheck[COLLINK])
+			//fmt.Println("hypperlinkjson", hypperlinkjson)
+			receipt, descrErr, err = fetchcheck(HeadOfCheck[COLFD], HeadOfCheck[COLFP], hypperlinkjson)
+			analyzeComlite := true
+			if err != nil {
+				logsmap[LOGERROR].Println(descrErr)
+				analyzeComlite = false
 			}
-			fmt.Printf("Введите сумму оплаты безналичными (%v):\n", summsOfPayment[COLBEZ])
-			input.Scan()
-			bezch := summsOfPayment[COLBEZ]
-			bezstr := input.Text()
-			if notEmptyFloatField(bezstr) {
-				bezClean := strings.ReplaceAll(bezstr, " ", "")
-				bezch, err = strconv.ParseFloat(bezClean, 64)
-				if err != nil {
-					descrErr := fmt.Sprintf("ошибка (%v) парсинга строки %v для безналичного расчёта", err, bezstr)
-					logsmap[LOGERROR].Println(descrErr)
+			if analyzeComlite {
+				summsOfPayment[COLNAL] = float64(receipt.Document.Amount_Cash) / 100
+				summsOfPayment[COLBEZ] = float64(receipt.Document.Amount_ECash) / 100
+				summsOfPayment[COLCREDIT] = float64(receipt.Document.Amount_Loan) / 100
+				summsOfPayment[COLAVANCE] = float64(receipt.Document.Amount_Advance) / 100
+				summsOfPayment[COLVSTRECHPREDST] = float64(receipt.Document.Amount_Granting) / 100
+				for k, v := range summsOfPayment {
+					paymentsOfHead[k] = v
 				}
-			}
-			fmt.Printf("Введите сумму оплаты дебетом (%v):\n", summsOfPayment[COLAVANCE])
-			input.Scan()
-			avnch := summsOfPayment[COLAVANCE]
-			avnstr := input.Text()
-			if notEmptyFloatField(avnstr) {
-				avnClean := strings.ReplaceAll(avnstr, " ", "")
-				avnch, err = strconv.ParseFloat(avnClean, 64)
-				if err != nil {
-					descrErr := fmt.Sprintf("ошибка (%v) парсинга строки %v для аванса", err, avnstr)
-					logsmap[LOGERROR].Println(descrErr)
+				if HeadOfCheck[COLOSN] == "" && receipt.Document.TaxationType != 0 {
+					HeadOfCheck[COLOSN] = strconv.Itoa(receipt.Document.TaxationType)
 				}
+				mistakesInPayment, descrMistakesInPayment = checkMistakeInPayments(amountOfCheck, summsOfPayment, findedPositions)
 			}
-			fmt.Printf("Введите сумму оплаты кредитом (%v):\n", summsOfPayment[COLCREDIT])
-			input.Scan()
-			crdch := summsOfPayment[COLCREDIT]
-			crdstr := input.Text()
-			if notEmptyFloatField(crdstr) {
-				crdClean := strings.ReplaceAll(crdstr, " ", "")
-				crdch, err = strconv.ParseFloat(crdClean, 64)
-				if err != nil {
-					descrErr := fmt.Sprintf("ошибка (%v) парсинга строки %v для кредита расчёта", err, crdstr)
-					logsmap[LOGERROR].Println(descrErr)
-				}
+		}
+		if mistakesInPayment {
+			logsmap[LOGERROR].Printf("ошибка в суммах оплат чека %v: %v", checkDescrInfo, descrMistakesInPayment)
+		}
+		if mistakesInPayment {
+			if !resolveMistakeInPayments(summsOfPayment, paymentsOfHead, paymentsOfPoss, amountOfCheck, checkDescrInfo) {
+				logsmap[LOGSKIP_LINES].Printf("чек %v помещён в карантин: %v (оплаты: %v)", checkDescrInfo, descrMistakesInPayment, descrOfPayments(summsOfPayment))
+				continue
 			}
-			fmt.Printf("Введите сумму оплаты кредитом (%v):\n", summsOfPayment[COLVSTRECHPREDST])
-			input.Scan()
-			vstrch := summsOfPayment[COLVSTRECHPREDST]
-			vstrstr := input.Text()
-			if notEmptyFloatField(vstrstr) {
-				vstrClean := strings.ReplaceAll(vstrstr, " ", "")
-				vstrch, err = strconv.ParseFloat(vstrClean, 64)
-				if err != nil {
-					descrErr := fmt.Sprintf("ошибка (%v) парсинга строки %v для встречного представления", err, vstrstr)
-					logsmap[LOGERROR].Println(descrErr)
-				}
+			mistakesInPayment, descrMistakesInPayment = checkMistakeInPayments(amountOfCheck, summsOfPayment, findedPositions)
+			if mistakesInPayment {
+				logsmap[LOGSKIP_LINES].Printf("чек %v помещён в карантин: после исправления сумм оплат остались ошибки: %v (оплаты: %v)", checkDescrInfo, descrMistakesInPayment, descrOfPayments(summsOfPayment))
+				continue
 			}
-			summsOfPayment[COLNAL] = nalch
-			summsOfPayment[COLBEZ] = bezch
-			summsOfPayment[COLAVANCE] = avnch
-			summsOfPayment[COLCREDIT] = crdch
-			summsOfPayment[COLVSTRECHPREDST] = vstrch
-			summPaymentsCurrDescr = fmt.Sprintf("Сейчас суммы оплат такие: наличными %v", summsOfPayment[COLNAL])
-			summPaymentsCurrDescr += fmt.Sprintf(", картой %v", summsOfPayment[COLBEZ])
-			summPaymentsCurrDescr += fmt.Sprintf(", кредитом %v", summsOfPayment[COLCREDIT])
-			summPaymentsCurrDescr += fmt.Sprintf(", дебетом %v", summsOfPayment[COLAVANCE])
-			summPaymentsCurrDescr += fmt.Sprintf(", встречным представлением %v", summsOfPayment[COLVSTRECHPREDST])
-			fmt.Println(summPaymentsCurrDescr)
-			logginInFile("суммы оплат были изменены")
-			logginInFile(deskMistPaym)
-			logginInFile(summPaymentsCurrDescr)
-			fmt.Printf("Нажмите любую клавишу для продолжения формирования json-заданий...")
-			input.Scan()
 		}
 		//fmt.Println("------------------------------")
 		//fmt.Println("summsOfPayment", summsOfPayment)
@@ -1064,6 +1137,12 @@ func main() {
 					analyzeComlite = false
 					break
 				}
+				if HeadOfCheck[COLOSN] == "" && receipt.Document.TaxationType != 0 {
+					HeadOfCheck[COLOSN] = strconv.Itoa(receipt.Document.TaxationType)
+				}
+				if *reissue && originalHeadOfCheck[COLOSN] == "" && receipt.Document.TaxationType != 0 {
+					originalHeadOfCheck[COLOSN] = strconv.Itoa(receipt.Document.TaxationType)
+				}
 				//saveReceiptToDisk(HeadOfCheck[COLFD], HeadOfCheck[COLFP], receipt)
 				//fmt.Println("receipt", receipt)
 				//fmt.Println("сохраняем запрос в файл")
@@ -1145,19 +1224,22 @@ func main() {
 					if markOfField == "" {
 						continue
 					}
-					for _, posFined := range findedPositions {
-						//fmt.Printf("posFined=%v.\n", posFined[COLNAME])
-						if strings.EqualFold(strings.ToLower(strings.TrimSpace(itemPos.Name)), strings.ToLower(strings.TrimSpace(posFined[COLNAME]))) {
-							//fmt.Println("нашли позицию", itemPos.Name)
-							//logsmap[LOG]
-							//posFined[COLMARK] = itemPos.ProductCode.Code_GS_1M
-							if posFined[COLMARK] != "" {
-								//fmt.Println("позиция уже имеет марку", posFined[COLMARK])
-								continue
+					//марки записываются и в исправленный, и в исходный (для режима -reissue) чек
+					for _, possOfCheck := range []map[int]map[string]string{findedPositions, originalPositions} {
+						for _, posFined := range possOfCheck {
+							//fmt.Printf("posFined=%v.\n", posFined[COLNAME])
+							if strings.EqualFold(strings.ToLower(strings.TrimSpace(itemPos.Name)), strings.ToLower(strings.TrimSpace(posFined[COLNAME]))) {
+								//fmt.Println("нашли позицию", itemPos.Name)
+								//logsmap[LOG]
+								//posFined[COLMARK] = itemPos.ProductCode.Code_GS_1M
+								if posFined[COLMARK] != "" {
+									//fmt.Println("позиция уже имеет марку", posFined[COLMARK])
+									continue
+								}
+								posFined[COLMARK] = markOfField
+								posFined[NAMETYPEOFMARK] = nameTypeOfMark
+								break
 							}
-							posFined[COLMARK] = markOfField
-							posFined[NAMETYPEOFMARK] = nameTypeOfMark
-							break
 						}
 					}
 					analyzeComlite = true
@@ -1170,6 +1252,22 @@ func main() {
 		if (countOfPositions > 0) && analyzeComlite { //если для чека были найдены позиции
 			logginInFile("генерируем json файл")
 			//jsonres, descError, err := generateCheckCorrection(headOfCheckkassir, innkassir, dateCh, fd, fp, typeCheck, *email, nal, bez, avance, kred, obmen, findedPositions)
+			var taskReverse TTaskOfCheck
+			suffixOfFile := ""
+			if *reissue {
+				prepareReverseOfCheck(originalHeadOfCheck, originalPositions, originalSummsOfPayment, summsOfPayment, checkDescrInfo)
+				jsonreverse, descError, err := generateCheckCorrection(originalHeadOfCheck, originalPositions)
+				if err != nil {
+					descrError := fmt.Sprintf("ошибка (%v) полчуение json обратного чека коррекции (%v)", descError, checkDescrInfo)
+					logsmap[LOGERROR].Println(descrError)
+					continue //пропускаем чек
+				}
+				//обратная коррекция записывается только вместе с прямой, иначе её печать отменит чек без повторного пробития
+				if taskReverse, err = prepareTaskOfCheck(jsonreverse, originalHeadOfCheck, REISSUEREVERSESUFFIX, checkDescrInfo); err != nil {
+					continue //пропускаем чек
+				}
+				suffixOfFile = REISSUEFIXEDSUFFIX
+			}
 			jsonres, descError, err := generateCheckCorrection(HeadOfCheck, findedPositions)
 			//for k, v := range jsonres.Items {
 			//fmt.Println("name", v.Name)
@@ -1184,68 +1282,29 @@ func main() {
 				logsmap[LOGERROR].Println(descrError)
 				continue //пропускаем чек
 			}
-			loggstr := fmt.Sprintln(jsonres)
-			logginInFile(loggstr)
-			as_json, err := json.MarshalIndent(jsonres, "", "\t")
-			if err != nil {
-				descrError := fmt.Sprintf("ошибка (%v) преобразвания объекта в json для чека %v", err, checkDescrInfo)
-				logsmap[LOGERROR].Println(descrError)
-				continue //пропускаем чек
-			}
-			dir_file_name := fmt.Sprintf("%v%v/", JSONRES, HeadOfCheck[COLFNKKT])
-			if foundedLogDir, _ := doesFileExist(dir_file_name); !foundedLogDir {
-				logginInFile("генерируем папку результатов, если раньше она не была сгенерирована")
-				os.Mkdir(dir_file_name, 0777)
-				f, err := os.Create(dir_file_name + "printed.txt")
-				if err == nil {
-					f.Close()
-				}
-				f, err = os.Create(dir_file_name + "connection.txt")
-				if err == nil {
-					f.Close()
+			if !*reissue {
+				if err := writeTaskOfCheck(jsonres, HeadOfCheck, suffixOfFile, checkDescrInfo); err != nil {
+					continue //пропускаем чек
 				}
+				countWritedChecks++
+				continue
 			}
-			str_name_file := fmt.Sprintf("%v_%v", HeadOfCheck[COLFNKKT], HeadOfCheck[COLFD])
-			if HeadOfCheck[COLFD] == "" {
-				//str_name_file = fmt.Sprintf("%v_%v_%v.json", HeadOfCheck[COLFNKKT], HeadOfCheck[COLFD])
-				num_sm_str := HeadOfCheck[FieldsNames[COLBINDHEADFIELDKASSA]]
-				name_file_numb := 0
-				name_file_numb_str := ""
-				num_sm, err_sm := strconv.ParseInt(num_sm_str, 10, 64)
-				//logginInFile(fmt.Sprintf("num_sm=%v, err_sm=%v", num_sm, err_sm))
-				if err_sm == nil {
-					name_file_numb = int(num_sm) * 10000
-				}
-				num_ch_str := HeadOfCheck[FieldsNames[COLBINDHEADDIELDCHECK]]
-				num_ch, err_ch := strconv.ParseInt(num_ch_str, 10, 64)
-				//logginInFile(fmt.Sprintf("num_ch=%v, err_ch=%v", num_ch, err_ch))
-				if err_ch == nil {
-					name_file_numb = name_file_numb + int(num_ch)
-				}
-				//logginInFile(fmt.Sprintf("name_file_numb=%v", name_file_numb))
-				if err_sm == nil && err_ch == nil {
-					name_file_numb_str = strconv.Itoa(name_file_numb)
-				} else {
-					name_file_numb_str = num_sm_str + num_ch_str
-				}
-				str_name_file = fmt.Sprintf("%v_%v", HeadOfCheck[COLFNKKT], name_file_numb_str)
-				//str_name_file = fmt.Sprintf("%v.json", HeadOfCheck[COLFNKKT])
-			}
-			file_name := fmt.Sprintf("%v%v/%v.json", JSONRES, HeadOfCheck[COLFNKKT], str_name_file)
-			f, err := os.Create(file_name)
+			taskFixed, err := prepareTaskOfCheck(jsonres, HeadOfCheck, suffixOfFile, checkDescrInfo)
 			if err != nil {
-				descrError := fmt.Sprintf("ошибка (%v) создания файла json чека (%v)", err, checkDescrInfo)
-				logsmap[LOGERROR].Println(descrError)
+				logsmap[LOGERROR].Printf("для чека %v не записано ни одно задание пары -reissue", checkDescrInfo)
 				continue //пропускаем чек
 			}
-			_, err = f.Write(as_json)
-			if err != nil {
-				descrError := fmt.Sprintf("ошибка (%v) записи json задания в файл (%v)", err, checkDescrInfo)
-				logsmap[LOGERROR].Println(descrError)
-				f.Close()
+			if err := saveTaskOfCheck(taskReverse, checkDescrInfo); err != nil {
 				continue //пропускаем чек
 			}
-			f.Close()
+			if err := saveTaskOfCheck(taskFixed, checkDescrInfo); err != nil {
+				if errRemove := os.Remove(taskReverse.FullFileName); errRemove != nil {
+					logsmap[LOGERROR].Printf("ошибка (%v) удаления задания обратной коррекции %v без пары для чека %v", errRemove, taskReverse.FullFileName, checkDescrInfo)
+				}
+				continue //пропускаем чек
+			}
+			addTaskToPrintPlan(taskReverse)
+			addTaskToPrintPlan(taskFixed)
 			countWritedChecks++
 			//panic("ok2")
 		} else {
@@ -1258,6 +1317,7 @@ func main() {
 			}
 		} //если для чека были найдены позиции
 	} //перебор чеков
+	buildPrintPlans()
 	logsmap[LOGINFO_WITHSTD].Println("формирование json заданий завершено")
 	logsmap[LOGINFO_WITHSTD].Printf("обработано %v из %v чеков", countWritedChecks, countAllChecks)
 	logsmap[LOGINFO_WITHSTD].Println("проверка завершена")
@@ -1265,6 +1325,179 @@ func main() {
 	input.Scan()
 }
 
+// initFieldsOfTemplate - инициализация названий колонок шаблона выбранного ОФД и списков полей чека
+func initFieldsOfTemplate(data map[string]interface{}) {
+	logginInFile("инициализация номеров колонок")
+	FieldsNums = make(map[string]int)
+	FieldsNames = make(map[string]string)
+	for k, v := range data[OFD].(map[string]interface{}) {
+		logginInFile(fmt.Sprintf("k=%v, v=%v", k, v))
+		FieldsNames[k] = fmt.Sprint(v)
+	}
+	for k := range data["fields"].(map[string]interface{})["kkt"].(map[string]interface{}) {
+		AllFieldsHeadOfCheck = append(AllFieldsHeadOfCheck, k)
+	}
+	for k := range data["fields"].(map[string]interface{})["check"].(map[string]interface{}) {
+		//logginInFile(fmt.Sprintf("check field %v", k))
+		//logginInFile(fmt.Sprintf("check field %v", data["fields"].(map[string]interface{})["check"].(map[string]interface{})[k]))
+		AllFieldsHeadOfCheck = append(AllFieldsHeadOfCheck, k)
+	}
+	for k := range data["fields"].(map[string]interface{})["positions"].(map[string]interface{}) {
+		AllFieldPositionsOfCheck = append(AllFieldPositionsOfCheck, k)
+	}
+	for k := range data["fields"].(map[string]interface{})["others"].(map[string]interface{}) {
+		AllFieldOtherOfCheck = append(AllFieldOtherOfCheck, k)
+	}
+}
+
+// задание чека коррекции, подготовленное к записи в файл
+type TTaskOfCheck struct {
+	CheckCorr    TCorrection
+	FN           string
+	OutFormat    string
+	NameOfFile   string //имя файла без расширения
+	NumOfCheck   int    //номер ФД (или номер смены*10000+номер чека, если ФД нет) для порядка чеков в плане печати
+	FullFileName string
+	Data         []byte
+}
+
+// writeTaskOfCheck - запись задания чека коррекции в папку ФН в формате драйвера ФН (-outformat, [output.fn]).
+// suffix добавляется к имени файла (для пары заданий режима -reissue), при этом номер ФД дополняется нулями,
+// чтобы задания шли по порядку
+func writeTaskOfCheck(checkCorr TCorrection, headofcheck map[string]string, suffix, checkDescrInfo string) error {
+	task, err := prepareTaskOfCheck(checkCorr, headofcheck, suffix, checkDescrInfo)
+	if err != nil {
+		return err
+	}
+	if err := saveTaskOfCheck(task, checkDescrInfo); err != nil {
+		return err
+	}
+	addTaskToPrintPlan(task)
+	return nil
+}
+
+// prepareTaskOfCheck - формирование задания в формате драйвера ФН и имени его файла без записи на диск
+func prepareTaskOfCheck(checkCorr TCorrection, headofcheck map[string]string, suffix, checkDescrInfo string) (TTaskOfCheck, error) {
+	loggstr := fmt.Sprintln(checkCorr)
+	logginInFile(loggstr)
+	task := TTaskOfCheck{CheckCorr: checkCorr, FN: headofcheck[COLFNKKT]}
+	task.OutFormat = getOutFormatOfFN(headofcheck[COLFNKKT])
+	writer := CorrectionWriters[task.OutFormat]
+	as_json, err := writer.Marshal(checkCorr)
+	if err != nil {
+		descrError := fmt.Sprintf("ошибка (%v) формирования задания в формате %v для чека %v", err, task.OutFormat, checkDescrInfo)
+		logsmap[LOGERROR].Println(descrError)
+		return task, err
+	}
+	task.Data = as_json
+	str_name_file := fmt.Sprintf("%v_%v", headofcheck[COLFNKKT], headofcheck[COLFD])
+	task.NumOfCheck, _ = strconv.Atoi(strings.TrimSpace(headofcheck[COLFD]))
+	if headofcheck[COLFD] == "" {
+		//str_name_file = fmt.Sprintf("%v_%v_%v.json", headofcheck[COLFNKKT], headofcheck[COLFD])
+		num_sm_str := headofcheck[FieldsNames[COLBINDHEADFIELDKASSA]]
+		name_file_numb := 0
+		name_file_numb_str := ""
+		num_sm, err_sm := strconv.ParseInt(num_sm_str, 10, 64)
+		//logginInFile(fmt.Sprintf("num_sm=%v, err_sm=%v", num_sm, err_sm))
+		if err_sm == nil {
+			name_file_numb = int(num_sm) * 10000
+		}
+		num_ch_str := headofcheck[FieldsNames[COLBINDHEADDIELDCHECK]]
+		num_ch, err_ch := strconv.ParseInt(num_ch_str, 10, 64)
+		//logginInFile(fmt.Sprintf("num_ch=%v, err_ch=%v", num_ch, err_ch))
+		if err_ch == nil {
+			name_file_numb = name_file_numb + int(num_ch)
+		}
+		//logginInFile(fmt.Sprintf("name_file_numb=%v", name_file_numb))
+		if err_sm == nil && err_ch == nil {
+			name_file_numb_str = strconv.Itoa(name_file_numb)
+			task.NumOfCheck = name_file_numb
+		} else {
+			name_file_numb_str = num_sm_str + num_ch_str
+		}
+		str_name_file = fmt.Sprintf("%v_%v", headofcheck[COLFNKKT], name_file_numb_str)
+		//str_name_file = fmt.Sprintf("%v.json", headofcheck[COLFNKKT])
+	}
+	if suffix != "" {
+		if numFD, errFD := strconv.Atoi(strings.TrimSpace(headofcheck[COLFD])); errFD == nil {
+			str_name_file = fmt.Sprintf("%v_%010d", headofcheck[COLFNKKT], numFD)
+		}
+		str_name_file += "_" + suffix
+	}
+	task.NameOfFile = str_name_file
+	task.FullFileName = fmt.Sprintf("%v%v/%v.%v", JSONRES, headofcheck[COLFNKKT], str_name_file, writer.FileExt())
+	return task, nil
+}
+
+// saveTaskOfCheck - запись подготовленного задания в папку ФН
+func saveTaskOfCheck(task TTaskOfCheck, checkDescrInfo string) error {
+	dir_file_name := fmt.Sprintf("%v%v/", JSONRES, task.FN)
+	if foundedLogDir, _ := doesFileExist(dir_file_name); !foundedLogDir {
+		logginInFile("генерируем папку результатов, если раньше она не была сгенерирована")
+		os.Mkdir(dir_file_name, 0777)
+		f, err := os.Create(dir_file_name + "printed.txt")
+		if err == nil {
+			f.Close()
+		}
+		f, err = os.Create(dir_file_name + "connection.txt")
+		if err == nil {
+			f.Close()
+		}
+	}
+	f, err := os.Create(task.FullFileName)
+	if err != nil {
+		descrError := fmt.Sprintf("ошибка (%v) создания файла задания чека (%v)", err, checkDescrInfo)
+		logsmap[LOGERROR].Println(descrError)
+		return err
+	}
+	_, err = f.Write(task.Data)
+	if err != nil {
+		descrError := fmt.Sprintf("ошибка (%v) записи задания в файл (%v)", err, checkDescrInfo)
+		logsmap[LOGERROR].Println(descrError)
+		f.Close()
+		return err
+	}
+	f.Close()
+	return nil
+}
+
+// addTaskToPrintPlan - добавление записанного задания в план печати (есть только для заданий атол)
+func addTaskToPrintPlan(task TTaskOfCheck) {
+	if task.OutFormat == OUTFORMATATOL {
+		addReceiptToPrintPlan(task.FN, task.NameOfFile+".json", task.NumOfCheck, getAtolCheckOfCorrection(task.CheckCorr))
+	}
+}
+
+// getAmountOfPositions - сумма позиций чека. Если сумма позиции не указана, она вычисляется по цене и количеству
+func getAmountOfPositions(poss map[int]map[string]string, strInfoAboutCheck string) float64 {
+	amountOfCheck := 0.0
+	for _, pos := range poss {
+		amountClean := strings.ReplaceAll(pos[COLAMOUNTPOS], " ", "")
+		spos, errgen := strconv.ParseFloat(amountClean, 64)
+		if errgen != nil {
+			priceClean := strings.ReplaceAll(pos[COLPRICE], " ", "")
+			prloc, errlocpr := strconv.ParseFloat(priceClean, 64)
+			quantityClean := strings.ReplaceAll(pos[COLQUANTITY], " ", "")
+			quloc, errlocqt := strconv.ParseFloat(quantityClean, 64)
+			if (errlocpr != nil) || (errlocqt != nil) {
+				descrErr := fmt.Sprintf("ошибка (%v, %v) парсинга строки (%v, %v) суммы для чека %v", errlocpr, errlocqt, pos[COLPRICE], pos[COLQUANTITY], strInfoAboutCheck)
+				logsmap[LOGERROR].Println(descrErr)
+			} else {
+				spos = prloc * quloc
+				errgen = nil
+				pos[COLAMOUNTPOS] = fmt.Sprint(spos)
+			}
+		}
+		if errgen != nil {
+			descrErr := fmt.Sprintf("ошибка (%v) парсинга строки %v суммы для чека %v", errgen, pos[COLAMOUNTPOS], strInfoAboutCheck)
+			logsmap[LOGERROR].Println(descrErr)
+			continue
+		}
+		amountOfCheck += spos
+	}
+	return amountOfCheck
+}
+
 func fillFieldsNumByPositionTable(fieldsnames map[string]string, fieldsnums map[string]int, filename, partOfCheck string) error {
 	fullnameoffile := DIRINFILES + filename
 	existfile, _ := doesFileExist(fullnameoffile)
@@ -1296,9 +1529,55 @@ func fillFieldsNumByPositionTable(fieldsnames map[string]string, fieldsnums map[
 		if lineoffields[:5] == ";;;;;" {
 			currNumbLineOfHead = currNumbLineOfHead + 1
 		}
-		getNumberOfFieldsInCSV(lines[currNumbLineOfHead], fieldsnames, fieldsnums, partOfCheck)
+		getNumberOfFieldsInCSV(lines[currNumbLineOfHead], fieldsnames, fieldsnums, partOfCheck)
+	}
+	return nil
+}
+
+// getPositionOfLine - поля позиции из строки файла позиций (или плоской таблицы). Суммы позиции по видам оплат
+// (поля шапки, заданные в позициях через inv) добавляются в summsPayment
+func getPositionOfLine(line []string, fieldsnames map[string]string, fieldsnums map[string]int, summsPayment map[string]float64) map[string]string {
+	res := make(map[string]string)
+	for _, field := range AllFieldsHeadOfCheck {
+		if isInvField(fieldsnames[field]) {
+			curValOfField := getfieldval(line, fieldsnums, field)
+			curValOfField = strings.TrimSpace(curValOfField)
+			res["inv$"+field] = curValOfField
+			//получаем суммы оплат
+			if field == COLNAL || field == COLBEZ || field == COLAVANCE || field == COLCREDIT ||
+				field == COLVSTRECHPREDST {
+				if notEmptyFloatField(curValOfField) {
+					currSumm, errDescr, err := getFloatFromStr(getfieldval(line, fieldsnums, COLAMOUNTPOS))
+					if err != nil {
+						logsmap[LOGERROR].Println(errDescr, line)
+						continue
+					}
+					//if OFD == "ofdru" {
+					//} else {
+					summsPayment[field] = summsPayment[field] + currSumm
+					//}
+				}
+			}
+		}
 	}
-	return nil
+	//logginInFile(fmt.Sprintln("AllFieldPositionsOfCheck", AllFieldPositionsOfCheck))
+	//logginInFile(fmt.Sprintln("fieldsnames", fieldsnames))
+	//logginInFile(fmt.Sprintln("fieldsnums", fieldsnums))
+	for _, field := range AllFieldPositionsOfCheck {
+		logginInFile(fmt.Sprintln("field", field))
+		//logginInFile(fmt.Sprintln("fieldsnames[field]", fieldsnames[field]))
+		if !isInvField(fieldsnames[field]) {
+			//logginInFile(fmt.Sprintln("notinv"))
+			//logginInFile(fmt.Sprintln("fieldsnums[field]", fieldsnums[field]))
+			//logginInFile(fmt.Sprintln("val=", getfieldval(line, fieldsnums, field)))
+			res[field] = getfieldval(line, fieldsnums, field)
+			if _, ok := stavkaNDSOfColumn[field]; ok {
+				res[PREFSUMMNDS+field] = getSummNDSFromLine(line, fieldsnums, field)
+			}
+			//logsmap[LOGINFO_WITHSTD].Println(field)
+		}
+	}
+	return res
 }
 
 func findPositions(valbindkassainhead, valbindcheckinhead string, fieldsnames map[string]string, fieldsnums map[string]int, passedPositions *[]int) (map[int]map[string]string, map[string]float64) {
@@ -1379,43 +1658,7 @@ func findPositions(valbindkassainhead, valbindcheckinhead string, fieldsnames ma
 		wasfindedpositions = true
 		logginInFile(logstr)
 		currPos++
-		res[currPos] = make(map[string]string)
-		for _, field := range AllFieldsHeadOfCheck {
-			if isInvField(fieldsnames[field]) {
-				curValOfField := getfieldval(line, fieldsnums, field)
-				curValOfField = strings.TrimSpace(curValOfField)
-				res[currPos]["inv$"+field] = curValOfField
-				//получаем суммы оплат
-				if field == COLNAL || field == COLBEZ || field == COLAVANCE || field == COLCREDIT ||
-					field == COLVSTRECHPREDST {
-					if notEmptyFloatField(curValOfField) {
-						currSumm, errDescr, err := getFloatFromStr(getfieldval(line, fieldsnums, COLAMOUNTPOS))
-						if err != nil {
-							logsmap[LOGERROR].Println(errDescr, line)
-							continue
-						}
-						//if OFD == "ofdru" {
-						//} else {
-						summsPayment[field] = summsPayment[field] + currSumm
-						//}
-					}
-				}
-			}
-		}
-		//logginInFile(fmt.Sprintln("AllFieldPositionsOfCheck", AllFieldPositionsOfCheck))
-		//logginInFile(fmt.Sprintln("fieldsnames", fieldsnames))
-		//logginInFile(fmt.Sprintln("fieldsnums", fieldsnums))
-		for _, field := range AllFieldPositionsOfCheck {
-			logginInFile(fmt.Sprintln("field", field))
-			//logginInFile(fmt.Sprintln("fieldsnames[field]", fieldsnames[field]))
-			if !isInvField(fieldsnames[field]) {
-				//logginInFile(fmt.Sprintln("notinv"))
-				//logginInFile(fmt.Sprintln("fieldsnums[field]", fieldsnums[field]))
-				//logginInFile(fmt.Sprintln("val=", getfieldval(line, fieldsnums, field)))
-				res[currPos][field] = getfieldval(line, fieldsnums, field)
-				//logsmap[LOGINFO_WITHSTD].Println(field)
-			}
-		}
+		res[currPos] = getPositionOfLine(line, fieldsnames, fieldsnums, summsPayment)
 		if (OFD == "platforma") || (OFD == "firstofd") || (OFD == "taxcom") || (OFD == "conturofd") {
 			//ищем марки в таблице марок
 			logginInFile("ищем марки в дполнительном файле платформы ОФД")
@@ -1570,13 +1813,13 @@ func findMarkInOtherFile(kassa, doc1, doc2, posnum string, fieldsnums map[string
 	return marka, nil
 }
 
-func generateCheckCorrection(headofcheck map[string]string, poss map[int]map[string]string) (TCorrectionCheck, string, error) {
-	var checkCorr TCorrectionCheck
+func generateCheckCorrection(headofcheck map[string]string, poss map[int]map[string]string) (TCorrection, string, error) {
+	var checkCorr TCorrection
 	strInfoAboutCheck := fmt.Sprintf("(ФД %v, ФП %v %v)", headofcheck[COLFD], headofcheck[COLFP], headofcheck[COLDATE])
 	chekcCorrTypeLoc := ""
 	typeCheck := strings.ToLower(headofcheck[COLTAG1054])
-	//если нужно сделать операцию обратной
-	if *reverseoper {
+	//если нужно сделать операцию обратной (задание обратной коррекции режима -reissue обращает флаг -reverse)
+	if *reverseoper != (headofcheck[REVERSEOPERFIELD] == "1") {
 		if typeCheck == "приход" {
 			typeCheck = "возврат прихода"
 		} else if typeCheck == "расход" {
@@ -1617,42 +1860,51 @@ func generateCheckCorrection(headofcheck map[string]string, poss map[int]map[str
 		logsmap[LOGERROR].Println(descError)
 		return checkCorr, descError, errors.New("ошибка определения типа чека коррекции")
 	}
+	//чек в том виде, в котором он был зарегистрирован (обратная коррекция -reissue), не преобразуется
+	asRegistered := headofcheck[ASREGISTEREDFIELD] == "1"
 	osnLoc := getOsnFromChernovVal(headofcheck[COLOSN])
-	if *changeSNOCustom {
-		osnLoc = "usnIncomeOutcome"
+	if osnOverride := getOsnOverride(headofcheck); (osnOverride != "") && !asRegistered {
+		logginInFile(fmt.Sprintf("система налогообложения %v заменена на %v по таблице переопределения %v", osnLoc, osnOverride, strInfoAboutCheck))
+		osnLoc = osnOverride
+	}
+	if (headofcheck[FORCEDSNOFIELD] != "") && !asRegistered {
+		osnLoc = headofcheck[FORCEDSNOFIELD]
 	}
 	if osnLoc != "" {
 		checkCorr.TaxationType = osnLoc
 	}
-	//strconv.ParseBool
-	checkCorr.Electronically, _ = strconv.ParseBool(headofcheck[NOPRINTFIELD])
-	if headofcheck[EMAILFIELD] == "" {
-		checkCorr.Electronically = false
-	} else {
-		checkCorr.Electronically = true
-	}
-	correctionType := "self"
-	correctionBaseNumber := ""
-	if *byPrescription {
-		correctionType = "instruction"
-		correctionBaseNumber = *docNumbOfPrescription
-	}
-	checkCorr.CorrectionType = correctionType
-	//if OFD == "customer" {
-	//checkCorr.CorrectionBaseDate = ""
-	//} else {
-	checkCorr.CorrectionBaseDate = headofcheck[COLDATE]
-	//}
-	checkCorr.CorrectionBaseNumber = correctionBaseNumber
-	checkCorr.ClientInfo.EmailOrPhone = headofcheck[EMAILFIELD]
-	checkCorr.Operator.Name = headofcheck[COLKASSIR]
-	if headofcheck[COLINNCLIENT] != "" {
-		checkCorr.ClientInfo.Vatin = headofcheck[COLINNCLIENT]
+	checkCorr.INN = strings.TrimSpace(headofcheck[COLINN])
+	summsNDSOfPoss := make(map[string]float64)
+	//суммы НДС позиций из выгрузки ОФД по ставкам и число позиций ставки, для которых они есть
+	exportSummsNDSOfPoss := make(map[string]float64)
+	countOfExportSummsNDS := make(map[string]int)
+	countOfPossByStavka := make(map[string]int)
+	var industryKeysOfPoss []TIndustryKey
+	checkCorr.CorrectionReason = fmt.Sprintf("исправление чека ФД %v ФП %v от %v", headofcheck[COLFD], headofcheck[COLFP], headofcheck[COLDATE])
+	checkCorr.Descr = strInfoAboutCheck
+	fillCorrectionBasis(&checkCorr, headofcheck)
+	if err := checkCorrectionBasis(checkCorr, headofcheck); err != nil {
+		descrErr := fmt.Sprintf("ошибка (%v) основания коррекции %v", err, strInfoAboutCheck)
+		logsmap[LOGERROR].Println(descrErr)
+		return checkCorr, descrErr, err
+	}
+	if err := fillClientContact(&checkCorr, headofcheck, strInfoAboutCheck); err != nil {
+		descrErr := fmt.Sprintf("ошибка (%v) %v", err, strInfoAboutCheck)
+		logsmap[LOGERROR].Println(descrErr)
+		return checkCorr, descrErr, err
+	}
+	operator, err := getOperatorOfCheck(headofcheck, strInfoAboutCheck)
+	if err != nil {
+		descrErr := fmt.Sprintf("ошибка (%v) %v", err, strInfoAboutCheck)
+		logsmap[LOGERROR].Println(descrErr)
+		return checkCorr, descrErr, err
 	}
-	if headofcheck[COLNAMECLIENT] != "" {
-		checkCorr.ClientInfo.Name = headofcheck[COLNAMECLIENT]
+	checkCorr.Operator = operator
+	if err := fillClientInfo(&checkCorr.ClientInfo, headofcheck, strInfoAboutCheck); err != nil {
+		descrErr := fmt.Sprintf("ошибка (%v) сведений о покупателе %v", err, strInfoAboutCheck)
+		logsmap[LOGERROR].Println(descrErr)
+		return checkCorr, descrErr, err
 	}
-	checkCorr.Operator.Vatin = headofcheck[COLINNKASSIR]
 	nal := headofcheck[COLNAL]
 	if notEmptyFloatField(nal) {
 		nalClean := strings.ReplaceAll(nal, " ", "")
@@ -1722,15 +1974,10 @@ func generateCheckCorrection(headofcheck map[string]string, poss map[int]map[str
 		orignFD = extractNumber(orignFD)
 	}
 	//в тег 1192 - записываем ФП //(если нет ФП, то записываем ФД) - отменил
-	if (currFP != "") || (currFD != "") {
-		newAdditionalAttribute := TTag1192_91{Type: "additionalAttribute"}
-		if currFP != "" {
-			newAdditionalAttribute.Value = currFP
-		} else {
-			newAdditionalAttribute.Value = currFD
-		}
-		newAdditionalAttribute.Print = true
-		checkCorr.Items = append(checkCorr.Items, newAdditionalAttribute)
+	if currFP != "" {
+		checkCorr.AdditionalAttribute = currFP
+	} else {
+		checkCorr.AdditionalAttribute = currFD
 	}
 	strDop1 := ""
 	strDop2 := ""
@@ -1742,21 +1989,13 @@ func generateCheckCorrection(headofcheck map[string]string, poss map[int]map[str
 		strDop2 = " чека"
 	}
 	if (currFD != "") && (currFP != "") {
-		newAdditionalAttribute := TTag1192_91{Type: "userAttribute"}
-		newAdditionalAttribute.Name = "ФД" + strDop1
-		newAdditionalAttribute.Value = headofcheck[COLFD]
-		newAdditionalAttribute.Print = true
-		checkCorr.Items = append(checkCorr.Items, newAdditionalAttribute)
+		checkCorr.UserAttributes = append(checkCorr.UserAttributes, TCorrectionUserAttribute{Name: "ФД" + strDop1, Value: headofcheck[COLFD]})
 	}
 	if orignFD != "" {
-		newAdditionalAttribute := TTag1192_91{Type: "userAttribute"}
-		newAdditionalAttribute.Name = "ФД" + strDop2
-		newAdditionalAttribute.Value = orignFD
-		newAdditionalAttribute.Print = true
-		checkCorr.Items = append(checkCorr.Items, newAdditionalAttribute)
+		checkCorr.UserAttributes = append(checkCorr.UserAttributes, TCorrectionUserAttribute{Name: "ФД" + strDop2, Value: orignFD})
 	}
 	for _, pos := range poss {
-		newPos := TPosition{Type: "position"}
+		var newPos TCorrectionPosition
 		newPos.Name = pos[COLNAME]
 		quantityClean := strings.ReplaceAll(pos[COLQUANTITY], " ", "")
 		qch, err := strconv.ParseFloat(quantityClean, 64)
@@ -1805,73 +2044,41 @@ func generateCheckCorrection(headofcheck map[string]string, poss map[int]map[str
 		if math.Round(qch) != qch {
 			measunit = getMeasUnitFromStr(*measurementUnitOfFracQuantSimple)
 		}
+		//единица измерения из данных ОФД имеет приоритет над значением, введённым при запуске
+		unitFromData := ""
+		if pos[COLUNIT] != "" {
+			if unit, ok := getMeasUnitFromDict(pos[COLUNIT]); ok {
+				unitFromData = unit
+				measunit = unit
+			} else {
+				logsmap[LOGERROR].Printf("не удалось определить единицу измерения \"%v\" для позиции %v %v, используется %v", pos[COLUNIT], pos[COLNAME], strInfoAboutCheck, measunit)
+			}
+		}
 		newPos.MeasurementUnit = measunit //liter
 		newPos.PaymentMethod = getSposobRash(pos[COLSPOSOB])
 		//commodityWithMarking
 		newPos.PaymentObject = getPredmRasch(pos[COLPREDMET])
-		newPos.Tax = new(TTaxNDS)
-		stavkaNDSStr := STAVKANDSNONE
-		if pos[COLSTAVKANDS20] != "" {
-			stavkaNDSStr = STAVKANDS20
-		} else if pos[COLSTAVKANDS5] != "" {
-			stavkaNDSStr = STAVKANDS5
-		} else if pos[COLSTAVKANDS7] != "" {
-			stavkaNDSStr = STAVKANDS7
-		} else if pos[COLSTAVKANDS10] != "" {
-			stavkaNDSStr = STAVKANDS10
-		} else if pos[COLSTAVKANDS0] != "" {
-			stavkaNDSStr = STAVKANDS0
-		} else if pos[COLSTAVKANDS120] != "" {
-			stavkaNDSStr = STAVKANDS120
-		} else if pos[COLSTAVKANDS110] != "" {
-			stavkaNDSStr = STAVKANDS110
-		}
-		logginInFile(fmt.Sprintln("pos[COLSTAVKANDS]=", pos[COLSTAVKANDS]))
-		if pos[COLSTAVKANDS] != "" {
-			if strings.Contains(pos[COLSTAVKANDS], "20") {
-				stavkaNDSStr = STAVKANDS20
-			} else if strings.Contains(pos[COLSTAVKANDS], "10") {
-				stavkaNDSStr = STAVKANDS10
-			} else if strings.Contains(pos[COLSTAVKANDS], "5") {
-				stavkaNDSStr = STAVKANDS5
-			} else if strings.Contains(pos[COLSTAVKANDS], "7") {
-				stavkaNDSStr = STAVKANDS7
-			} else if strings.Contains(pos[COLSTAVKANDS], "0%") || strings.Contains(pos[COLSTAVKANDS], "0 %") {
-				stavkaNDSStr = STAVKANDS0
-			}
-			//if pos[COLSTAVKANDS] != "НДС не облагается" {
-			//	logsmap[LOGERROR].Printf("требуется доработка программы для учёта ставки НДС %v в чеке", pos[COLSTAVKANDS])
-			//}
-		}
+		stavkaNDSStr := getStavkaNDSOfPos(pos, sch, strInfoAboutCheck)
 
-		if *changeNDSCustom {
-			stavkaNDSStr = STAVKANDSNONE
+		if (pos[FORCEDNDSFIELD] != "") && !asRegistered {
+			stavkaNDSStr = pos[FORCEDNDSFIELD]
 		}
-
-		newPos.Tax.Type = stavkaNDSStr
-		postDataExist := false
-		if pos[COLPRIZAGENTA] != "" {
-			if strings.ToUpper(pos[COLPRIZAGENTA]) == "КОМИССИОНЕР" {
-				postDataExist = true
-			}
-		} else {
-			if pos[COLINNOFSUPPLIER] != "" {
-				postDataExist = true
-			}
+		if *prepaymentRaschRates && !asRegistered {
+			stavkaNDSStr = getStavkaNDSOfPaymentMethod(stavkaNDSStr, newPos.PaymentMethod, pos[COLNAME], strInfoAboutCheck)
 		}
-		if postDataExist {
-			newPos.AgentInfo = new(TAgentInfo)
-			newPos.AgentInfo.Agents = append(newPos.AgentInfo.Agents, "commissionAgent")
-			newPos.SupplierInfo = new(TSupplierInfo)
-			newPos.SupplierInfo.Vatin = pos[COLINNOFSUPPLIER]
-			//teststrloc1 := fmt.Sprintf("Номер колнки ИНН поставщика %v", COLINNOFSUPPLIER)
-			//teststrloc2 := fmt.Sprintf("ИНН поставщика %v", pos[COLINNOFSUPPLIER])
-			//logginInFile(teststrloc1)
-			//logginInFile(teststrloc2)
-			newPos.SupplierInfo.Name = pos[COLNAMEOFSUPPLIER]
-			if pos[COLTELOFSUPPLIER] != "" {
-				newPos.SupplierInfo.Phones = append(newPos.SupplierInfo.Phones, pos[COLTELOFSUPPLIER])
-			}
+		summsNDSOfPoss[stavkaNDSStr] += getSummNDS(sch, stavkaNDSStr)
+		countOfPossByStavka[stavkaNDSStr]++
+		if summNDS, ok := getExportSummNDS(pos, PREFSUMMNDS, stavkaNDSStr, nil); ok {
+			exportSummsNDSOfPoss[stavkaNDSStr] += summNDS
+			countOfExportSummsNDS[stavkaNDSStr]++
+		}
+
+		newPos.Tax = stavkaNDSStr
+		newPos.AgentInfo, newPos.SupplierInfo, err = getAgentOfPos(pos, strInfoAboutCheck)
+		if err != nil {
+			descrErr := fmt.Sprintf("ошибка (%v) данных агента позиции %v %v", err, pos[COLNAME], strInfoAboutCheck)
+			logsmap[LOGERROR].Println(descrErr)
+			return checkCorr, descrErr, err
 		}
 		//chanePredmetRascheta := false
 		if pos[COLMARK] != "" {
@@ -1879,43 +2086,431 @@ func generateCheckCorrection(headofcheck map[string]string, poss map[int]map[str
 				newPos.PaymentObject = "commodityWithMarking"
 			}
 			measunit = "piece"
-			if qch != 1 {
+			if (unitFromData == "") && (qch != 1) {
 				measunit = getMeasUnitFromStr(*measurementUnitOfFracQuantMark)
 				newPos.MeasurementUnit = measunit
 			}
-			currMark := pos[COLMARK]
-			if (pos[NAMETYPEOFMARK] == "Undefined") || (pos[NAMETYPEOFMARK] == "EAN_8") ||
-				(pos[NAMETYPEOFMARK] == "EAN_13") || (pos[NAMETYPEOFMARK] == "ITF_14") {
-				newPos.ProductCodes = new(TProductCodesAtol)
-				setMarkInArolDriverCorrenspOFDMark(newPos.ProductCodes, currMark, pos[NAMETYPEOFMARK])
-			} else {
-				currMarkInBase64 := base64.StdEncoding.EncodeToString([]byte(currMark))
-				//newPos.Mark = currMarkInBase64
-				newPos.ImcParams = new(TImcParams)
-				//newPos.ImcParams.ImcType = "auto"
-				newPos.ImcParams.Imc = currMarkInBase64
-				//newPos.ImcParams.ItemEstimatedStatus = "itemStatusUnchanged" //статус товара не изменился
-				//newPos.ImcParams.ItemUnits = measunit
-				//newPos.ImcParams.ImcModeProcessing = 0
-				////newPos.ImcParams.ImcBarcode
-				//newPos.ImcParams.ItemQuantity = newPos.Quantity
-				//newPos.ImcParams.ItemInfoCheckResult = new(TItemInfoCheckResult)
-				//newPos.ImcParams.ItemInfoCheckResult.ImcCheckFlag = true
-				//newPos.ImcParams.ItemInfoCheckResult.ImcCheckResult = true
-				//newPos.ImcParams.ItemInfoCheckResult.ImcStatusInfo = true
-				//newPos.ImcParams.ItemInfoCheckResult.ImcEstimatedStatusCorrect = true
-				//newPos.ImcParams.ItemInfoCheckResult.EcrStandAloneFlag = false
-				////chanePredmetRascheta = true
-			}
-			//if chanePredmetRascheta {
-			//	newPos.PaymentObject = addMarkToPredmetRasheta(newPos.PaymentObject)
-			//}
+			newPos.Mark = pos[COLMARK]
+			newPos.MarkType = pos[NAMETYPEOFMARK]
+		}
+		//код товара (EAN, ITF и т.п.) из справочника или шаблона не является кодом маркировки
+		if productCode := strings.TrimSpace(pos[COLPRODUCTCODE]); (newPos.Mark == "") && (productCode != "") {
+			newPos.Mark = productCode
+			newPos.MarkType = getTypeOfProductCode(productCode)
+		}
+		newPos.IndustryInfo, err = getIndustryInfoOfPos(pos, newPos.PaymentObject, !asRegistered)
+		if err != nil {
+			descrErr := fmt.Sprintf("ошибка (%v) отраслевого реквизита позиции %v %v", err, pos[COLNAME], strInfoAboutCheck)
+			logsmap[LOGERROR].Println(descrErr)
+			return checkCorr, descrErr, err
 		}
-		checkCorr.Items = append(checkCorr.Items, newPos)
+		industryKeysOfPoss = append(industryKeysOfPoss, TIndustryKey{PaymentObject: newPos.PaymentObject, ProductGroup: pos[COLPRODUCTGROUP]})
+		checkCorr.Positions = append(checkCorr.Positions, newPos)
 	} //запись всех позиций чека
+	industryInfo, err := getIndustryInfoOfCheck(headofcheck, industryKeysOfPoss, !asRegistered)
+	if err != nil {
+		descrErr := fmt.Sprintf("ошибка (%v) отраслевого реквизита чека %v", err, strInfoAboutCheck)
+		logsmap[LOGERROR].Println(descrErr)
+		return checkCorr, descrErr, err
+	}
+	checkCorr.IndustryInfo = industryInfo
+	reconcileSummsNDS(headofcheck, summsNDSOfPoss, len(poss), strInfoAboutCheck)
+	for _, pos := range checkCorr.Positions {
+		checkCorr.Total += pos.Amount
+	}
+	checkCorr.Total = roundKopecks(checkCorr.Total)
+	checkCorr.Taxes = getTaxesWithExportSummsNDS(getTaxesOfCorrection(checkCorr), headofcheck, exportSummsNDSOfPoss,
+		countOfExportSummsNDS, countOfPossByStavka, strInfoAboutCheck)
 	return checkCorr, "", nil
 }
 
+// getTaxesWithExportSummsNDS - суммы НДС чека по ставкам, в которых рассчитанные по позициям суммы заменены суммами
+// из выгрузки ОФД: из столбцов шапки чека или суммой по позициям, если сумма НДС выгружена для всех позиций ставки
+func getTaxesWithExportSummsNDS(taxes []TCorrectionTax, headofcheck map[string]string, exportSummsNDSOfPoss map[string]float64,
+	countOfExportSummsNDS, countOfPossByStavka map[string]int, strInfoAboutCheck string) []TCorrectionTax {
+	var stavkiOfCheck []string
+	for _, tax := range taxes {
+		stavkiOfCheck = append(stavkiOfCheck, tax.Type)
+	}
+	for i, tax := range taxes {
+		summNDS, ok := getExportSummNDS(headofcheck, "inv$"+PREFSUMMNDS, tax.Type, stavkiOfCheck)
+		if !ok && countOfExportSummsNDS[tax.Type] > 0 && countOfExportSummsNDS[tax.Type] == countOfPossByStavka[tax.Type] {
+			summNDS, ok = roundKopecks(exportSummsNDSOfPoss[tax.Type]), true
+		}
+		if !ok {
+			continue
+		}
+		if math.Abs(summNDS-tax.Sum) > 0.01*float64(countOfPossByStavka[tax.Type]+1) {
+			logsmap[LOGREPORT].Printf("чек %v: сумма НДС %v взята из выгрузки ОФД (%v), по позициям рассчитано %v", strInfoAboutCheck, tax.Type, summNDS, tax.Sum)
+		}
+		taxes[i].Sum = summNDS
+	}
+	return taxes
+}
+
+// getStavkaNDSOfPos - ставка НДС позиции. Ставка берётся из заполненного столбца суммы НДС
+// (в порядке приоритета) или из текстового столбца ставки. Если в позиции есть суммы НДС,
+// то ставка проверяется (и при необходимости определяется) по отношению суммы НДС к сумме позиции
+func getStavkaNDSOfPos(pos map[string]string, amount float64, strInfoAboutCheck string) string {
+	stavkaNDSStr := getDeclaredStavkaNDS(pos)
+	logginInFile(fmt.Sprintln("pos[COLSTAVKANDS]=", pos[COLSTAVKANDS]))
+	if (pos[COLSTAVKANDS] != "") && (getStavkaNDSFromStr(pos[COLSTAVKANDS]) == "") {
+		logsmap[LOGERROR].Printf("не удалось определить ставку НДС по значению \"%v\" для позиции %v %v, используется %v", pos[COLSTAVKANDS], pos[COLNAME], strInfoAboutCheck, stavkaNDSStr)
+	}
+	//ставки, которым соответствуют суммы НДС позиции
+	var stavkiBySumm []string
+	summsExist := false
+	for _, col := range stavkaNDSColumns {
+		summStr := pos[PREFSUMMNDS+col]
+		if summStr == "" {
+			continue
+		}
+		summsExist = true
+		summNDS, _, err := getFloatFromStr(summStr)
+		if err != nil {
+			continue
+		}
+		stavkaOfCol := stavkaNDSOfColumn[col]
+		if stavkaOfCol == STAVKANDS0 {
+			//для ставки 0% в столбце указывается сумма расчёта, а не сумма НДС
+			if math.Abs(summNDS-amount) <= getToleranceOfSummNDS(amount) {
+				stavkiBySumm = append(stavkiBySumm, stavkaOfCol)
+			}
+			continue
+		}
+		if math.Abs(summNDS-getSummNDS(amount, stavkaOfCol)) <= getToleranceOfSummNDS(amount) {
+			stavkiBySumm = append(stavkiBySumm, stavkaOfCol)
+		}
+	}
+	if !summsExist {
+		return stavkaNDSStr
+	}
+	if len(stavkiBySumm) == 0 {
+		logsmap[LOGERROR].Printf("суммы НДС позиции %v (сумма %v) не соответствуют ни одной ставке НДС %v, используется ставка %v", pos[COLNAME], amount, strInfoAboutCheck, stavkaNDSStr)
+		return stavkaNDSStr
+	}
+	if slices.Contains(stavkiBySumm, stavkaNDSStr) {
+		return stavkaNDSStr
+	}
+	stavkaBySumm := stavkiBySumm[0]
+	//ставка, указанная текстом, может отличаться от столбца только расчётностью (20% и 20/120)
+	if procDecl, ok := getProcOfStavkaNDS(stavkaNDSStr); ok {
+		if procSumm, _ := getProcOfStavkaNDS(stavkaBySumm); procSumm == procDecl {
+			return stavkaNDSStr
+		}
+	}
+	logsmap[LOGERROR].Printf("заявленная ставка НДС %v позиции %v не соответствует сумме НДС (ставка по сумме НДС %v, сумма позиции %v) %v, используется ставка %v", stavkaNDSStr, pos[COLNAME], stavkaBySumm, amount, strInfoAboutCheck, stavkaBySumm)
+	return stavkaBySumm
+}
+
+// moveSummsNDSOfCheckToHead - в некоторых отчетах (например, суммы НДС по тегам 1102-1107) в каждой строке
+// позиции указывается сумма НДС всего чека. Такие суммы переносятся в шапку чека, чтобы не
+// определять по ним ставку отдельной позиции
+func moveSummsNDSOfCheckToHead(headofcheck map[string]string, poss map[int]map[string]string) {
+	if len(poss) < 2 {
+		return
+	}
+	for _, col := range stavkaNDSColumns {
+		summStr := ""
+		sameInAllPoss := true
+		fitsAllPoss := true
+		for _, pos := range poss {
+			if summStr == "" {
+				summStr = pos[PREFSUMMNDS+col]
+			}
+			if pos[PREFSUMMNDS+col] == "" || pos[PREFSUMMNDS+col] != summStr {
+				sameInAllPoss = false
+				break
+			}
+			amount, _, errAm := getFloatFromStr(pos[COLAMOUNTPOS])
+			summNDS, _, errSumm := getFloatFromStr(summStr)
+			if errAm != nil || errSumm != nil {
+				continue
+			}
+			expected := getSummNDS(amount, stavkaNDSOfColumn[col])
+			if stavkaNDSOfColumn[col] == STAVKANDS0 {
+				expected = amount
+			}
+			if math.Abs(summNDS-expected) > getToleranceOfSummNDS(amount) {
+				fitsAllPoss = false
+			}
+		}
+		if !sameInAllPoss || fitsAllPoss {
+			continue
+		}
+		logginInFile(fmt.Sprintf("сумма %v столбца %v указана для всего чека", summStr, col))
+		if headofcheck["inv$"+PREFSUMMNDS+col] == "" {
+			headofcheck["inv$"+PREFSUMMNDS+col] = summStr
+		}
+		for _, pos := range poss {
+			delete(pos, col)
+			delete(pos, PREFSUMMNDS+col)
+		}
+	}
+}
+
+// наибольшее число шагов подбора ставок НДС позиций по суммам НДС чека
+const MAXSTEPSOFSTAVKINDS = 100000
+
+// getAmountOfPosForNDS - сумма позиции (цена * количество, если сумма не указана)
+func getAmountOfPosForNDS(pos map[string]string) (float64, bool) {
+	if amount, _, err := getFloatFromStr(pos[COLAMOUNTPOS]); err == nil && amount != 0 {
+		return amount, true
+	}
+	price, _, errPr := getFloatFromStr(pos[COLPRICE])
+	quantity, _, errQu := getFloatFromStr(pos[COLQUANTITY])
+	if errPr != nil || errQu != nil {
+		return 0, false
+	}
+	return price * quantity, true
+}
+
+// getSummOfColumnNDS - значение столбца суммы НДС ставки stavka для суммы расчёта amount (для 0% - сама сумма расчёта)
+func getSummOfColumnNDS(amount float64, stavka string) float64 {
+	if stavka == STAVKANDS0 {
+		return amount
+	}
+	return getSummNDS(amount, stavka)
+}
+
+// isStavkaNDSOfPosDeclared - у позиции есть своя ставка НДС: столбец суммы НДС или текстовая ставка
+func isStavkaNDSOfPosDeclared(pos map[string]string) bool {
+	for _, col := range stavkaNDSColumns {
+		if pos[col] != "" {
+			return true
+		}
+	}
+	return getStavkaNDSFromStr(pos[COLSTAVKANDS]) != ""
+}
+
+// fillStavkiNDSBySummsOfCheck - ставки НДС позиций без своей ставки по суммам НДС всего чека (столбцы stavkaNDS* шапки).
+// При одной ставке чека она ставится всем таким позициям, при нескольких позиции подбираются по сумме к суммам НДС
+// по ставкам (за вычетом НДС позиций со своей ставкой). Если подбор невозможен или неоднозначен, возвращается
+// описание ошибки и false - чек помещается в карантин, чтобы не записать неверную ставку
+func fillStavkiNDSBySummsOfCheck(headofcheck map[string]string, poss map[int]map[string]string, strInfoAboutCheck string) (string, bool) {
+	var stavkiOfCheck []string
+	remainders := make(map[string]float64)
+	for _, col := range stavkaNDSColumns {
+		summNDS, _, err := getFloatFromStr(headofcheck["inv$"+PREFSUMMNDS+col])
+		if headofcheck["inv$"+PREFSUMMNDS+col] == "" || err != nil {
+			continue
+		}
+		stavkiOfCheck = append(stavkiOfCheck, stavkaNDSOfColumn[col])
+		remainders[stavkaNDSOfColumn[col]] = summNDS
+	}
+	if len(stavkiOfCheck) == 0 {
+		return "", true
+	}
+	var numsOfAllPoss []int
+	for numPos := range poss {
+		numsOfAllPoss = append(numsOfAllPoss, numPos)
+	}
+	sort.Ints(numsOfAllPoss)
+	var numsOfPoss []int
+	var amounts []float64
+	for _, numPos := range numsOfAllPoss {
+		pos := poss[numPos]
+		amount, ok := getAmountOfPosForNDS(pos)
+		if isStavkaNDSOfPosDeclared(pos) {
+			stavka := getDeclaredStavkaNDS(pos)
+			if _, exist := remainders[stavka]; exist && ok {
+				remainders[stavka] -= getSummOfColumnNDS(amount, stavka)
+			}
+			continue
+		}
+		if !ok {
+			return fmt.Sprintf("не удалось определить сумму позиции %v \"%v\" для подбора ставки НДС", numPos, pos[COLNAME]), false
+		}
+		numsOfPoss = append(numsOfPoss, numPos)
+		amounts = append(amounts, amount)
+	}
+	if len(numsOfPoss) == 0 {
+		return "", true
+	}
+	var stavkiOfPoss []string
+	if len(stavkiOfCheck) == 1 {
+		for range numsOfPoss {
+			stavkiOfPoss = append(stavkiOfPoss, stavkiOfCheck[0])
+		}
+	} else {
+		//перебор ставок позиций: суммы НДС подобранных позиций должны совпасть с остатками сумм НДС чека по ставкам
+		tolerance := 0.01 * float64(len(poss)+1)
+		current := make([]string, len(numsOfPoss))
+		countOfSolutions := 0
+		steps := 0
+		var search func(i int)
+		search = func(i int) {
+			if countOfSolutions > 1 || steps > MAXSTEPSOFSTAVKINDS {
+				return
+			}
+			steps++
+			if i == len(numsOfPoss) {
+				for _, stavka := range stavkiOfCheck {
+					if math.Abs(remainders[stavka]) > tolerance {
+						return
+					}
+				}
+				countOfSolutions++
+				stavkiOfPoss = slices.Clone(current)
+				return
+			}
+			for _, stavka := range stavkiOfCheck {
+				summ := getSummOfColumnNDS(amounts[i], stavka)
+				if remainders[stavka]-summ < -tolerance {
+					continue
+				}
+				remainders[stavka] -= summ
+				current[i] = stavka
+				search(i + 1)
+				remainders[stavka] += summ
+			}
+		}
+		search(0)
+		if steps > MAXSTEPSOFSTAVKINDS || countOfSolutions > 1 {
+			return fmt.Sprintf("ставки НДС позиций по суммам НДС чека по ставкам %v подбираются неоднозначно", stavkiOfCheck), false
+		}
+		if countOfSolutions == 0 {
+			return fmt.Sprintf("позиции чека не удаётся сопоставить с суммами НДС чека по ставкам %v", stavkiOfCheck), false
+		}
+	}
+	for i, numPos := range numsOfPoss {
+		pos := poss[numPos]
+		pos[COLSTAVKANDS] = stavkiOfPoss[i]
+		logsmap[LOGREPORT].Printf("чек %v: позиции %v \"%v\" установлена ставка НДС %v по суммам НДС чека", strInfoAboutCheck, numPos, pos[COLNAME], stavkiOfPoss[i])
+	}
+	return "", true
+}
+
+// getDeclaredStavkaNDS - ставка НДС позиции, указанная в отчете ОФД: по заполненному столбцу суммы НДС
+// (в порядке приоритета) или по текстовому столбцу ставки
+func getDeclaredStavkaNDS(pos map[string]string) string {
+	stavkaNDSStr := STAVKANDSNONE
+	for _, col := range stavkaNDSColumns {
+		if pos[col] != "" {
+			stavkaNDSStr = stavkaNDSOfColumn[col]
+			break
+		}
+	}
+	if pos[COLSTAVKANDS] != "" {
+		if stavkaFromStr := getStavkaNDSFromStr(pos[COLSTAVKANDS]); stavkaFromStr != "" {
+			stavkaNDSStr = stavkaFromStr
+		}
+	}
+	return stavkaNDSStr
+}
+
+// getExportSummNDS - сумма НДС по ставке stavka из столбца выгрузки ОФД (поле prefix+stavkaNDS*).
+// Сначала берётся столбец самой ставки. Для расчётной ставки подходит и столбец обычной ставки (20/120 и 20%)
+// и наоборот, так как ставка позиции могла быть заменена, но только если в чеке нет позиций со ставкой столбца
+// (stavkiOfCheck - ставки позиций чека, nil для одной позиции). Для 0% и без НДС суммы НДС нет
+func getExportSummNDS(fields map[string]string, prefix, stavka string, stavkiOfCheck []string) (float64, bool) {
+	if stavka == STAVKANDS0 || stavka == STAVKANDSNONE {
+		return 0, false
+	}
+	procOfStavka, _ := getProcOfStavkaNDS(stavka)
+	var cols []string
+	for _, col := range stavkaNDSColumns {
+		stavkaOfCol := stavkaNDSOfColumn[col]
+		if stavkaOfCol == stavka {
+			cols = append([]string{col}, cols...)
+			continue
+		}
+		if procOfCol, ok := getProcOfStavkaNDS(stavkaOfCol); !ok || stavkaOfCol == STAVKANDS0 || procOfCol != procOfStavka {
+			continue
+		}
+		if slices.Contains(stavkiOfCheck, stavkaOfCol) {
+			//столбец относится к позициям своей ставки
+			continue
+		}
+		cols = append(cols, col)
+	}
+	for _, col := range cols {
+		if fields[prefix+col] == "" {
+			continue
+		}
+		if summNDS, _, err := getFloatFromStr(fields[prefix+col]); err == nil {
+			return summNDS, true
+		}
+	}
+	return 0, false
+}
+
+// reconcileSummsNDS - сверка сумм НДС по ставкам, рассчитанных по позициям, с итоговыми суммами НДС чека из отчета ОФД
+func reconcileSummsNDS(headofcheck map[string]string, summsNDSOfPoss map[string]float64, countOfPoss int, strInfoAboutCheck string) {
+	for _, col := range stavkaNDSColumns {
+		summStr := headofcheck["inv$"+PREFSUMMNDS+col]
+		if summStr == "" {
+			continue
+		}
+		stavka := stavkaNDSOfColumn[col]
+		if stavka == STAVKANDS0 {
+			continue
+		}
+		summNDSOfCheck, _, err := getFloatFromStr(summStr)
+		if err != nil {
+			continue
+		}
+		tolerance := 0.01 * float64(countOfPoss+1)
+		if math.Abs(summNDSOfCheck-summsNDSOfPoss[stavka]) > tolerance {
+			logsmap[LOGERROR].Printf("сумма НДС %v по чеку %v не совпадает с суммой НДС %v, рассчитанной по позициям %v", stavka, summNDSOfCheck, math.Round(summsNDSOfPoss[stavka]*100)/100, strInfoAboutCheck)
+		}
+	}
+}
+
+// getStavkaNDSOfPaymentMethod - по правилам ФФД для предоплаты (полной и частичной) и аванса
+// применяются расчётные ставки НДС: 20% -> 20/120, 10% -> 10/110, 5% -> 5/105, 7% -> 7/107
+func getStavkaNDSOfPaymentMethod(stavka, paymentMethod, nameOfPos, strInfoAboutCheck string) string {
+	if (paymentMethod != "prepayment") && (paymentMethod != "fullPrepayment") && (paymentMethod != "advance") {
+		return stavka
+	}
+	res := stavka
+	switch stavka {
+	case STAVKANDS20:
+		res = STAVKANDS120
+	case STAVKANDS10:
+		res = STAVKANDS110
+	case STAVKANDS5:
+		res = STAVKANDS105
+	case STAVKANDS7:
+		res = STAVKANDS107
+	}
+	if res != stavka {
+		logsmap[LOGREPORT].Printf("чек %v: для позиции \"%v\" со способом расчёта %v ставка НДС %v заменена на расчётную %v", strInfoAboutCheck, nameOfPos, paymentMethod, stavka, res)
+	}
+	return res
+}
+
+// getProcOfStavkaNDS - процент ставки НДС (для vat20 и vat120 - 20). Для "none" второе значение false
+func getProcOfStavkaNDS(stavka string) (float64, bool) {
+	switch stavka {
+	case STAVKANDS0:
+		return 0, true
+	case STAVKANDS5, STAVKANDS105:
+		return 5, true
+	case STAVKANDS7, STAVKANDS107:
+		return 7, true
+	case STAVKANDS10, STAVKANDS110:
+		return 10, true
+	case STAVKANDS20, STAVKANDS120:
+		return 20, true
+	}
+	return 0, false
+}
+
+// getSummNDS - сумма НДС, входящая в сумму расчёта amount, по ставке stavka
+func getSummNDS(amount float64, stavka string) float64 {
+	proc, ok := getProcOfStavkaNDS(stavka)
+	if !ok {
+		return 0
+	}
+	return math.Round(amount*proc/(100+proc)*100) / 100
+}
+
+// getToleranceOfSummNDS - допустимое расхождение суммы НДС из-за округлений
+func getToleranceOfSummNDS(amount float64) float64 {
+	return math.Max(0.02, math.Abs(amount)*0.001)
+}
+
 //func addMarkToPredmetRasheta(predmet string) string {
 //	if predmet == "excise" {
 //		return "exciseWithMarking"
@@ -1992,7 +2587,7 @@ func InitializationLogsFiles() (string, error) {
 	//if foundedLogDir, _ := doesFileExist(RESULTSDIR); !foundedLogDir {
 	//	os.Mkdir(RESULTSDIR, 0777)
 	//}
-	filelogmap, logsmap, descrError, err = initializationLogs(*clearLogsProgramm, LOGINFO, LOGERROR, LOGSKIP_LINES, LOGOTHER)
+	filelogmap, logsmap, descrError, err = initializationLogs(*clearLogsProgramm, LOGINFO, LOGERROR, LOGSKIP_LINES, LOGOTHER, LOGREPORT)
 	if err != nil {
 		descrMistake := fmt.Sprintf("ошибка инициализации лог файлов %v", descrError)
 		fmt.Fprint(os.Stderr, descrMistake)
@@ -2138,17 +2733,14 @@ func getNumberOfFieldsInCSV(line []string, fieldsnames map[string]string, fields
 		fieldsOfBlock = AllFieldOtherOfCheck
 	} else if partOfCheck == "positions" {
 		fieldsOfBlock = AllFieldPositionsOfCheck
-	} else if partOfCheck == "union" {
-		fieldsOfBlock = AllFieldsUnionOfCheck
 	} else {
 		fieldsOfBlock = AllFieldsHeadOfCheck
 	}
-	logginInFile(fmt.Sprintf("AllFieldsUnionOfCheck=%v", AllFieldsUnionOfCheck))
 	//headAndNotOfPositions = AllFieldOtherOfCheck
 	logginInFile(fmt.Sprintf("fieldsnums=%v", fieldsnums))
 	fieldsnums = getNumberOfFieldsInCSVloc(line, fieldsnames, fieldsnums, fieldsOfBlock, true)
 	logginInFile(fmt.Sprintf("fieldsnums=%v", fieldsnums))
-	if (partOfCheck == "other") || (partOfCheck == "union") {
+	if partOfCheck == "other" {
 		return fieldsnums
 	}
 	if partOfCheck == "head" {
@@ -2195,24 +2787,9 @@ func getfieldval(line []string, fieldsnum map[string]int, name string) string {
 	if strings.Contains(name, "stavkaNDS") {
 		if notEmptyFloatField(resVal) {
 			if name != "stavkaNDS" {
-				switch name {
-				//case "stavkaNDS":
-				//	resVal = resVal
-				case "stavkaNDS0":
-					resVal = STAVKANDS0
-				case "stavkaNDS5":
-					resVal = STAVKANDS5
-				case "stavkaNDS7":
-					resVal = STAVKANDS7
-				case "stavkaNDS10":
-					resVal = STAVKANDS10
-				case "stavkaNDS20":
-					resVal = STAVKANDS20
-				case "stavkaNDS110":
-					resVal = STAVKANDS110
-				case "stavkaNDS120":
-					resVal = STAVKANDS120
-				default:
+				if stavka, ok := stavkaNDSOfColumn[name]; ok {
+					resVal = stavka
+				} else {
 					resVal = STAVKANDSNONE
 				}
 			}
@@ -2245,6 +2822,20 @@ func getfieldval(line []string, fieldsnum map[string]int, name string) string {
 	return resVal
 }
 
+// getSummNDSFromLine - значение суммы из столбца ставки НДС (stavkaNDS20 и т.п.) в виде числа,
+// пустая строка, если сумма не указана
+func getSummNDSFromLine(line []string, fieldsnum map[string]int, name string) string {
+	num, ok := fieldsnum[name]
+	if !ok || num >= len(line) {
+		return ""
+	}
+	resVal := formatMyNumber(line[num])
+	if !notEmptyFloatField(resVal) {
+		return ""
+	}
+	return resVal
+}
+
 func fetchcheck(fd, fp, hyperlinkonjson string) (TReceiptOFD, string, error) {
 	var receipt TReceiptOFD
 	var resp *http.Response
@@ -2312,16 +2903,108 @@ func isInvField(fieldname string) bool {
 	return res
 }
 
+// getSposobRash - способ расчёта (тег 1214) в формате драйвера атол по текстовому значению,
+// коду тега или названию атол. По умолчанию - полный расчёт
 func getSposobRash(sposob string) string {
-	res := "fullPayment"
-	if strings.Contains(strings.ToUpper(sposob), "ПРЕДОПЛАТА 100%") {
-		res = "fullPrepayment"
+	sposobClean := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(sposob)), "ё", "е")
+	switch sposobClean {
+	case "1", "fullprepayment":
+		return "fullPrepayment"
+	case "2", "prepayment", "предоплата", "частичная предоплата":
+		return "prepayment"
+	case "3", "advance", "аванс":
+		return "advance"
+	case "4", "fullpayment":
+		return "fullPayment"
+	case "5", "partialpayment":
+		return "partialPayment"
+	case "6", "credit":
+		return "credit"
+	case "7", "creditpayment":
+		return "creditPayment"
+	}
+	switch {
+	case strings.Contains(sposobClean, "предоплата 100%"):
+		return "fullPrepayment"
+	case strings.Contains(sposobClean, "частичный расчет"):
+		return "partialPayment"
+	case strings.Contains(sposobClean, "передача в кредит"):
+		return "credit"
+	case strings.Contains(sposobClean, "оплата кредита"):
+		return "creditPayment"
+	}
+	return "fullPayment"
+}
+
+var regexpStavkaNDSRasch = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*%?\s*/\s*(\d+(?:\.\d+)?)`)
+var regexpStavkaNDSProc = regexp.MustCompile(`\d+(?:\.\d+)?`)
+
+// getStavkaNDSFromStr - ставка НДС по текстовому значению из отчета ОФД:
+// "НДС 20%", "20%", "НДС 5/105", "20/120", "расч. ставка 7/107", "НДС не облагается", "без НДС", "vat105" и т.п.
+// Возвращает пустую строку, если ставку определить не удалось
+func getStavkaNDSFromStr(stavka string) string {
+	stavkaClean := strings.ToLower(strings.TrimSpace(stavka))
+	stavkaClean = strings.ReplaceAll(stavkaClean, ",", ".")
+	if stavkaClean == "" {
+		return ""
+	}
+	//значения в формате драйвера атол
+	switch stavkaClean {
+	case STAVKANDSNONE, STAVKANDS0, STAVKANDS5, STAVKANDS7, STAVKANDS10, STAVKANDS20,
+		STAVKANDS105, STAVKANDS107, STAVKANDS110, STAVKANDS120:
+		return stavkaClean
+	}
+	if strings.Contains(stavkaClean, "без") || strings.Contains(stavkaClean, "не облага") ||
+		strings.Contains(stavkaClean, "не подлеж") || stavkaClean == "-" {
+		return STAVKANDSNONE
+	}
+	//расчётная ставка: 20/120, 10/110, 5/105, 7/107
+	if parts := regexpStavkaNDSRasch.FindStringSubmatch(stavkaClean); parts != nil {
+		proc, _ := strconv.ParseFloat(parts[1], 64)
+		base, _ := strconv.ParseFloat(parts[2], 64)
+		if base != 100+proc {
+			return ""
+		}
+		return getStavkaNDSByProc(proc, true)
 	}
-	if strings.ToUpper(sposob) == "ПРЕДОПЛАТА" {
-		res = "prepayment"
+	procStr := regexpStavkaNDSProc.FindString(stavkaClean)
+	if procStr == "" {
+		return ""
 	}
-	if strings.EqualFold(sposob, "Аванс") {
-		res = "advance"
+	proc, _ := strconv.ParseFloat(procStr, 64)
+	//"расчетная ставка 20%"
+	rasch := strings.Contains(stavkaClean, "расч")
+	return getStavkaNDSByProc(proc, rasch)
+}
+
+// getStavkaNDSByProc - ставка НДС по проценту, rasch - расчётная ставка (20/120 и т.п.)
+func getStavkaNDSByProc(proc float64, rasch bool) string {
+	res := ""
+	switch proc {
+	case 0:
+		if !rasch {
+			res = STAVKANDS0
+		}
+	case 5:
+		res = STAVKANDS5
+		if rasch {
+			res = STAVKANDS105
+		}
+	case 7:
+		res = STAVKANDS7
+		if rasch {
+			res = STAVKANDS107
+		}
+	case 10:
+		res = STAVKANDS10
+		if rasch {
+			res = STAVKANDS110
+		}
+	case 20:
+		res = STAVKANDS20
+		if rasch {
+			res = STAVKANDS120
+		}
 	}
 	return res
 }
@@ -2329,26 +3012,141 @@ func getSposobRash(sposob string) string {
 func getOsnFromChernovVal(osnChernvVal string) string {
 	res := ""
 	logginInFile(fmt.Sprintf("osnChernvVal=%v", osnChernvVal))
-	switch strings.ToLower(osnChernvVal) {
-	case "осн":
+	osnClean := strings.ToLower(strings.TrimSpace(osnChernvVal))
+	osnClean = strings.ReplaceAll(osnClean, "ё", "е")
+	if osnClean == "" {
+		return res
+	}
+	//числовое значение тега 1055 - битовая маска (1 - ОСН, 2 - УСН доход, 4 - УСН доход-расход, 8 - ЕНВД, 16 - ЕСХН, 32 - ПСН)
+	if code, err := strconv.Atoi(osnClean); err == nil {
+		res = getOsnFromCode(code)
+		if res == "" {
+			logsmap[LOGERROR].Printf("не удалось определить систему налогообложения по коду %v тега 1055", osnChernvVal)
+		}
+		logginInFile(fmt.Sprintf("res=%v", res))
+		return res
+	}
+	//значения в формате драйвера атол
+	switch osnClean {
+	case "osn":
 		res = "osn"
-	case "усн доход":
+	case "usnincome":
 		res = "usnIncome"
-	case "усн доход-расход":
-		res = "usnIncomeOutcome"
-	case "упрощенная доход минус расход":
+	case "usnincomeoutcome":
 		res = "usnIncomeOutcome"
-	case "усн доход - расход":
-		res = "usnIncomeOutcome"
-	case "есн":
+	case "envd":
+		res = "envd"
+	case "esn":
+		res = "esn"
+	case "patent":
+		res = "patent"
+	}
+	if res != "" {
+		logginInFile(fmt.Sprintf("res=%v", res))
+		return res
+	}
+	//текстовые значения из отчетов ОФД: "ОСН", "УСН доход - расход", "Упрощенная доход минус расход", "УСН 15%" и т.п.
+	words := strings.FieldsFunc(osnClean, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	hasWord := func(prefixes ...string) bool {
+		for _, w := range words {
+			for _, pref := range prefixes {
+				if strings.HasPrefix(w, pref) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	hasExactWord := func(vals ...string) bool {
+		for _, w := range words {
+			if slices.Contains(vals, w) {
+				return true
+			}
+		}
+		return false
+	}
+	if hasExactWord("енвд") || hasWord("вмененн") {
+		res = "envd"
+	} else if hasExactWord("есхн", "есн") || hasWord("сельскохоз") {
 		res = "esn"
-	case "патент":
+	} else if hasExactWord("псн") || hasWord("патент") {
 		res = "patent"
+	} else if hasExactWord("усн") || hasWord("упрощ") {
+		res = "usnIncome"
+		if hasWord("расход") || hasExactWord("15", "др") {
+			res = "usnIncomeOutcome"
+		}
+	} else if hasExactWord("осн", "осно") || hasWord("общ", "основн", "традиц") {
+		res = "osn"
+	}
+	if res == "" {
+		logsmap[LOGERROR].Printf("не удалось определить систему налогообложения по значению \"%v\"", osnChernvVal)
 	}
 	logginInFile(fmt.Sprintf("res=%v", res))
 	return res
 }
 
+// getOsnFromCode - система налогообложения по числовому значению тега 1055.
+// В чеке может быть указана только одна система налогообложения, поэтому
+// значения с несколькими установленными битами не принимаются
+func getOsnFromCode(code int) string {
+	res := ""
+	switch code {
+	case 1:
+		res = "osn"
+	case 2:
+		res = "usnIncome"
+	case 4:
+		res = "usnIncomeOutcome"
+	case 8:
+		res = "envd"
+	case 16:
+		res = "esn"
+	case 32:
+		res = "patent"
+	}
+	return res
+}
+
+// initSnoOverrides - чтение таблицы переопределения системы налогообложения
+// по номеру ФН и ИНН (раздел [sno] файла init.toml)
+func initSnoOverrides(data map[string]interface{}) {
+	SnoOverridesFN = make(map[string]string)
+	SnoOverridesINN = make(map[string]string)
+	snoinit, ok := data["sno"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if custom, ok := snoinit["custom"].(string); ok && custom != "" {
+		SnoCustom = custom
+	}
+	if snofn, ok := snoinit["fn"].(map[string]interface{}); ok {
+		for k, v := range snofn {
+			SnoOverridesFN[strings.TrimLeft(k, "0")] = fmt.Sprint(v)
+		}
+	}
+	if snoinn, ok := snoinit["inn"].(map[string]interface{}); ok {
+		for k, v := range snoinn {
+			SnoOverridesINN[strings.TrimSpace(k)] = fmt.Sprint(v)
+		}
+	}
+	logginInFile(fmt.Sprintf("SnoOverridesFN=%v, SnoOverridesINN=%v, SnoCustom=%v", SnoOverridesFN, SnoOverridesINN, SnoCustom))
+}
+
+// getOsnOverride - система налогообложения из таблицы переопределения для ФН или ИНН чека.
+// Значение для ФН имеет приоритет над значением для ИНН
+func getOsnOverride(headofcheck map[string]string) string {
+	if osn, ok := SnoOverridesFN[strings.TrimLeft(headofcheck[COLFNKKT], "0")]; ok && headofcheck[COLFNKKT] != "" {
+		return getOsnFromChernovVal(osn)
+	}
+	if osn, ok := SnoOverridesINN[strings.TrimSpace(headofcheck[COLINN])]; ok && headofcheck[COLINN] != "" {
+		return getOsnFromChernovVal(osn)
+	}
+	return ""
+}
+
 func getPredmRasch(predm string) string {
 	res := "commodity"
 	switch strings.ToUpper(predm) {
@@ -2475,19 +3273,42 @@ func getBoolFromString(val string, onErrorDefault bool) (bool, error) {
 	return res, err
 }
 
-func checkMistakeInPayments(amountcheck float64, payments map[string]float64) bool {
-	resmist := false
-	nal := payments[COLNAL]
-	bez := payments[COLBEZ]
-	ava := payments[COLAVANCE]
-	crd := payments[COLCREDIT]
-	vst := payments[COLVSTRECHPREDST]
-	allnotnalsumm := bez + ava + crd + vst
-	allsumms := allnotnalsumm + nal
-	if allsumms > amountcheck {
-		resmist = true
+// checkMistakeInPayments - проверка сумм оплат чека: сумма оплат должна совпадать с суммой позиций
+// (с точностью -paymenttolerance), оплаты не могут быть отрицательными, а зачёт аванса и кредит
+// должны соответствовать способам расчёта позиций. Возвращает признак ошибки и её описание
+func checkMistakeInPayments(amountcheck float64, payments map[string]float64, poss map[int]map[string]string) (bool, string) {
+	var mistakes []string
+	for _, field := range PaymentsFields {
+		if payments[field] < 0 {
+			mistakes = append(mistakes, fmt.Sprintf("отрицательная сумма оплаты %v (%v)", field, payments[field]))
+		}
 	}
-	return resmist
+	allsumms := getTotalOfPayments(payments)
+	if allsumms > amountcheck+*paymentTolerance {
+		mistakes = append(mistakes, fmt.Sprintf("сумма оплат %v больше суммы чека %v", roundKopecks(allsumms), roundKopecks(amountcheck)))
+	}
+	if allsumms < amountcheck-*paymentTolerance {
+		mistakes = append(mistakes, fmt.Sprintf("сумма оплат %v меньше суммы чека %v", roundKopecks(allsumms), roundKopecks(amountcheck)))
+	}
+	existNotPrepaymentPos := false
+	existCreditPos := false
+	for _, pos := range poss {
+		switch getSposobRash(pos[COLSPOSOB]) {
+		case "fullPrepayment", "prepayment", "advance":
+		case "partialPayment", "credit":
+			existNotPrepaymentPos = true
+			existCreditPos = true
+		default:
+			existNotPrepaymentPos = true
+		}
+	}
+	if (payments[COLAVANCE] > 0) && (len(poss) > 0) && !existNotPrepaymentPos {
+		mistakes = append(mistakes, fmt.Sprintf("зачёт аванса %v при том, что все позиции чека - предоплата или аванс", payments[COLAVANCE]))
+	}
+	if (payments[COLCREDIT] > 0) && (len(poss) > 0) && !existCreditPos {
+		mistakes = append(mistakes, fmt.Sprintf("оплата кредитом %v при том, что в чеке нет позиций с частичным расчётом и кредитом или передачей в кредит", payments[COLCREDIT]))
+	}
+	return len(mistakes) > 0, strings.Join(mistakes, "; ")
 }
 
 func extractNumber(s string) string {
@@ -2502,15 +3323,62 @@ func extractNumber(s string) string {
 
 func getMeasUnitFromStr(s string) string {
 	res := "kilogram"
-	switch s {
-	case "л":
-		res = "liter"
-	case "грамм":
-		res = "gram"
-	case "иная":
-		res = "otherUnits"
-	case "шт":
-		res = "piece"
+	if unit, ok := getMeasUnitFromDict(s); ok {
+		res = unit
 	}
 	return res
 }
+
+// справочник единиц измерения (тег 2108): текстовые и числовые обозначения -> значение для драйвера
+var measUnitsDict = map[string]string{
+	"0": "piece", "шт": "piece", "штук": "piece", "штука": "piece", "штуки": "piece", "ед": "piece", "единица": "piece",
+	"единиц": "piece", "пара": "piece", "пар": "piece", "упак": "piece", "уп": "piece", "pcs": "piece",
+	"10": "gram", "г": "gram", "гр": "gram", "грамм": "gram", "граммов": "gram",
+	"11": "kilogram", "кг": "kilogram", "килограмм": "kilogram", "килограммов": "kilogram",
+	"12": "ton", "т": "ton", "тн": "ton", "тонна": "ton", "тонн": "ton",
+	"20": "centimeter", "см": "centimeter", "сантиметр": "centimeter",
+	"21": "decimeter", "дм": "decimeter", "дециметр": "decimeter",
+	"22": "meter", "м": "meter", "метр": "meter", "метров": "meter", "пм": "meter", "погм": "meter", "мп": "meter",
+	"30": "squareCentimeter", "см2": "squareCentimeter", "квсм": "squareCentimeter",
+	"31": "squareDecimeter", "дм2": "squareDecimeter", "квдм": "squareDecimeter",
+	"32": "squareMeter", "м2": "squareMeter", "квм": "squareMeter", "квметр": "squareMeter",
+	"40": "milliliter", "мл": "milliliter", "миллилитр": "milliliter",
+	"41": "liter", "л": "liter", "литр": "liter", "литров": "liter",
+	"42": "cubicMeter", "м3": "cubicMeter", "кубм": "cubicMeter", "мкуб": "cubicMeter",
+	"50": "kilowattHour", "квтч": "kilowattHour", "кватч": "kilowattHour",
+	"51": "gkal", "гкал": "gkal", "гигакалория": "gkal",
+	"70": "day", "сут": "day", "сутки": "day", "сутки(день)": "day", "день": "day", "дн": "day", "дней": "day",
+	"71": "hour", "ч": "hour", "час": "hour", "часов": "hour",
+	"72": "minute", "мин": "minute", "минута": "minute", "минут": "minute",
+	"73": "second", "с": "second", "сек": "second", "секунда": "second", "секунд": "second",
+	"80": "kilobyte", "кб": "kilobyte", "кбайт": "kilobyte", "килобайт": "kilobyte",
+	"81": "megabyte", "мб": "megabyte", "мбайт": "megabyte", "мегабайт": "megabyte",
+	"82": "gigabyte", "гб": "gigabyte", "гбайт": "gigabyte", "гигабайт": "gigabyte",
+	"83": "terabyte", "тб": "terabyte", "тбайт": "terabyte", "терабайт": "terabyte",
+	"255": "otherUnits", "иная": "otherUnits", "иные": "otherUnits", "прочие": "otherUnits",
+}
+
+// getMeasUnitFromDict - единица измерения для драйвера по значению из данных ОФД
+// ("шт", "кв.м", "кВт*ч", "Гкал", код тега 2108 и т.п.). Второе значение - найдена ли единица в справочнике
+func getMeasUnitFromDict(s string) (string, bool) {
+	unitClean := strings.ToLower(strings.TrimSpace(s))
+	unitClean = strings.ReplaceAll(unitClean, "ё", "е")
+	unitClean = strings.ReplaceAll(unitClean, "²", "2")
+	unitClean = strings.ReplaceAll(unitClean, "³", "3")
+	for _, r := range []string{" ", ".", "*", "·", "/"} {
+		unitClean = strings.ReplaceAll(unitClean, r, "")
+	}
+	if unitClean == "" {
+		return "", false
+	}
+	if unit, ok := measUnitsDict[unitClean]; ok {
+		return unit, true
+	}
+	//значения в формате драйвера (piece, squareMeter и т.п.)
+	for _, unit := range measUnitsDict {
+		if strings.EqualFold(unit, unitClean) {
+			return unit, true
+		}
+	}
+	return "", false
+}
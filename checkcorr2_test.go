@@ -0,0 +1,242 @@
+package main
+
+import (
+	"io"
+	"log"
+	"reflect"
+	"testing"
+)
+
+func TestGetTaxesWithExportSummsNDS(t *testing.T) {
+	logsmap = map[string]*log.Logger{LOGREPORT: log.New(io.Discard, "", 0)}
+	tests := []struct {
+		name      string
+		positions []TCorrectionPosition
+		head      map[string]string
+		want      []TAtolTaxSum
+	}{
+		{
+			name:      "20% и 20/120 со столбцами обеих ставок",
+			positions: []TCorrectionPosition{{Amount: 120, Tax: STAVKANDS20}, {Amount: 60, Tax: STAVKANDS120}},
+			head: map[string]string{"inv$" + PREFSUMMNDS + COLSTAVKANDS20: "20.01",
+				"inv$" + PREFSUMMNDS + COLSTAVKANDS120: "9.99"},
+			want: []TAtolTaxSum{{Type: STAVKANDS20, Sum: 20.01}, {Type: STAVKANDS120, Sum: 9.99}},
+		},
+		{
+			name:      "20% и 20/120 только со столбцом 20%",
+			positions: []TCorrectionPosition{{Amount: 120, Tax: STAVKANDS20}, {Amount: 60, Tax: STAVKANDS120}},
+			head:      map[string]string{"inv$" + PREFSUMMNDS + COLSTAVKANDS20: "20.01"},
+			want:      []TAtolTaxSum{{Type: STAVKANDS20, Sum: 20.01}, {Type: STAVKANDS120, Sum: 10}},
+		},
+		{
+			name:      "20% и 20/120 только со столбцом 20/120",
+			positions: []TCorrectionPosition{{Amount: 120, Tax: STAVKANDS20}, {Amount: 60, Tax: STAVKANDS120}},
+			head:      map[string]string{"inv$" + PREFSUMMNDS + COLSTAVKANDS120: "9.99"},
+			want:      []TAtolTaxSum{{Type: STAVKANDS20, Sum: 20}, {Type: STAVKANDS120, Sum: 9.99}},
+		},
+		{
+			name:      "только 20/120 со столбцом 20%",
+			positions: []TCorrectionPosition{{Amount: 60, Tax: STAVKANDS120}},
+			head:      map[string]string{"inv$" + PREFSUMMNDS + COLSTAVKANDS20: "9.99"},
+			want:      []TAtolTaxSum{{Type: STAVKANDS120, Sum: 9.99}},
+		},
+		{
+			name:      "только 20% со столбцом 20/120",
+			positions: []TCorrectionPosition{{Amount: 120, Tax: STAVKANDS20}},
+			head:      map[string]string{"inv$" + PREFSUMMNDS + COLSTAVKANDS120: "20.01"},
+			want:      []TAtolTaxSum{{Type: STAVKANDS20, Sum: 20.01}},
+		},
+		{
+			name:      "без столбцов сумм НДС",
+			positions: []TCorrectionPosition{{Amount: 120, Tax: STAVKANDS20}, {Amount: 60, Tax: STAVKANDS120}},
+			head:      map[string]string{},
+			want:      []TAtolTaxSum{{Type: STAVKANDS20, Sum: 20}, {Type: STAVKANDS120, Sum: 10}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkCorr := TCorrection{Positions: tt.positions}
+			checkCorr.Taxes = getTaxesWithExportSummsNDS(getTaxesOfCorrection(checkCorr), tt.head, nil, nil, nil, tt.name)
+			got := getAtolCheckOfCorrection(checkCorr).Taxes
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("суммы НДС %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFillStavkiNDSBySummsOfCheck(t *testing.T) {
+	logsmap = map[string]*log.Logger{LOGREPORT: log.New(io.Discard, "", 0), LOGERROR: log.New(io.Discard, "", 0)}
+	summsOfCheck := func(summ20, summ10 string) map[string]string {
+		head := make(map[string]string)
+		if summ20 != "" {
+			head["inv$"+PREFSUMMNDS+COLSTAVKANDS20] = summ20
+		}
+		if summ10 != "" {
+			head["inv$"+PREFSUMMNDS+COLSTAVKANDS10] = summ10
+		}
+		return head
+	}
+	tests := []struct {
+		name   string
+		head   map[string]string
+		poss   map[int]map[string]string
+		want   map[int]string
+		wantOk bool
+	}{
+		{
+			name:   "одна ставка чека",
+			head:   summsOfCheck("30", ""),
+			poss:   map[int]map[string]string{1: {COLAMOUNTPOS: "120"}, 2: {COLAMOUNTPOS: "60"}},
+			want:   map[int]string{1: STAVKANDS20, 2: STAVKANDS20},
+			wantOk: true,
+		},
+		{
+			name:   "20% и 10% подбираются по суммам",
+			head:   summsOfCheck("20", "10"),
+			poss:   map[int]map[string]string{1: {COLAMOUNTPOS: "110"}, 2: {COLAMOUNTPOS: "120"}},
+			want:   map[int]string{1: STAVKANDS10, 2: STAVKANDS20},
+			wantOk: true,
+		},
+		{
+			name:   "позиция со своей ставкой вычитается из суммы чека",
+			head:   summsOfCheck("20", "10"),
+			poss:   map[int]map[string]string{1: {COLAMOUNTPOS: "110", COLSTAVKANDS: "10%"}, 2: {COLAMOUNTPOS: "120"}},
+			want:   map[int]string{1: "10%", 2: STAVKANDS20},
+			wantOk: true,
+		},
+		{
+			name:   "равные суммы позиций - неоднозначно",
+			head:   summsOfCheck("20", "10.91"),
+			poss:   map[int]map[string]string{1: {COLAMOUNTPOS: "120"}, 2: {COLAMOUNTPOS: "120"}},
+			want:   map[int]string{1: "", 2: ""},
+			wantOk: false,
+		},
+		{
+			name:   "суммы НДС не подходят к позициям",
+			head:   summsOfCheck("50", "10"),
+			poss:   map[int]map[string]string{1: {COLAMOUNTPOS: "110"}, 2: {COLAMOUNTPOS: "120"}},
+			want:   map[int]string{1: "", 2: ""},
+			wantOk: false,
+		},
+		{
+			name:   "без сумм НДС чека",
+			head:   summsOfCheck("", ""),
+			poss:   map[int]map[string]string{1: {COLAMOUNTPOS: "110"}},
+			want:   map[int]string{1: ""},
+			wantOk: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok := fillStavkiNDSBySummsOfCheck(tt.head, tt.poss, tt.name)
+			if ok != tt.wantOk {
+				t.Errorf("результат %v, ожидался %v", ok, tt.wantOk)
+			}
+			for numPos, want := range tt.want {
+				if got := tt.poss[numPos][COLSTAVKANDS]; got != want {
+					t.Errorf("ставка позиции %v \"%v\", ожидалась \"%v\"", numPos, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGetStavkaNDSFromStr(t *testing.T) {
+	tests := []struct {
+		val  string
+		want string
+	}{
+		{"vat20", STAVKANDS20},
+		{"VAT105", STAVKANDS105},
+		{"none", STAVKANDSNONE},
+		{"20%", STAVKANDS20},
+		{"НДС 20%", STAVKANDS20},
+		{"20/120", STAVKANDS120},
+		{"НДС 20/120", STAVKANDS120},
+		{"расчетная ставка 20%", STAVKANDS120},
+		{"10%", STAVKANDS10},
+		{"10/110", STAVKANDS110},
+		{"НДС 5%", STAVKANDS5},
+		{"НДС 5/105", STAVKANDS105},
+		{"5 % / 105", STAVKANDS105},
+		{"расч. ставка 5%", STAVKANDS105},
+		{"7%", STAVKANDS7},
+		{"НДС 7/107", STAVKANDS107},
+		{"расч. ставка 7/107", STAVKANDS107},
+		{"0%", STAVKANDS0},
+		{"НДС 0", STAVKANDS0},
+		{"без НДС", STAVKANDSNONE},
+		{"Не облагается", STAVKANDSNONE},
+		{"-", STAVKANDSNONE},
+		{"", ""},
+		{"НДС 18%", ""},
+		{"20/110", ""},
+		{"0/100", ""},
+		{"ставка", ""},
+	}
+	for _, tt := range tests {
+		if got := getStavkaNDSFromStr(tt.val); got != tt.want {
+			t.Errorf("getStavkaNDSFromStr(\"%v\") = \"%v\", ожидалось \"%v\"", tt.val, got, tt.want)
+		}
+	}
+}
+
+func TestGetStavkaNDSByProc(t *testing.T) {
+	tests := []struct {
+		proc  float64
+		rasch bool
+		want  string
+	}{
+		{20, false, STAVKANDS20},
+		{20, true, STAVKANDS120},
+		{10, false, STAVKANDS10},
+		{10, true, STAVKANDS110},
+		{5, false, STAVKANDS5},
+		{5, true, STAVKANDS105},
+		{7, false, STAVKANDS7},
+		{7, true, STAVKANDS107},
+		{0, false, STAVKANDS0},
+		{0, true, ""},
+		{18, false, ""},
+	}
+	for _, tt := range tests {
+		if got := getStavkaNDSByProc(tt.proc, tt.rasch); got != tt.want {
+			t.Errorf("getStavkaNDSByProc(%v, %v) = \"%v\", ожидалось \"%v\"", tt.proc, tt.rasch, got, tt.want)
+		}
+	}
+}
+
+func TestGetOsnFromChernovVal(t *testing.T) {
+	logsmap = map[string]*log.Logger{LOGERROR: log.New(io.Discard, "", 0)}
+	tests := []struct {
+		val  string
+		want string
+	}{
+		{"osn", "osn"},
+		{"usnIncomeOutcome", "usnIncomeOutcome"},
+		{"1", "osn"},
+		{"2", "usnIncome"},
+		{"4", "usnIncomeOutcome"},
+		{"32", "patent"},
+		{"3", ""},
+		{"ОСН", "osn"},
+		{"Общая", "osn"},
+		{"УСН доход", "usnIncome"},
+		{"УСН доход - расход", "usnIncomeOutcome"},
+		{"Упрощенная доход минус расход", "usnIncomeOutcome"},
+		{"УСН 15%", "usnIncomeOutcome"},
+		{"УСН 6%", "usnIncome"},
+		{"ЕНВД", "envd"},
+		{"ЕСХН", "esn"},
+		{"Патентная система", "patent"},
+		{"ПСН", "patent"},
+		{"", ""},
+		{"неизвестная", ""},
+	}
+	for _, tt := range tests {
+		if got := getOsnFromChernovVal(tt.val); got != tt.want {
+			t.Errorf("getOsnFromChernovVal(\"%v\") = \"%v\", ожидалось \"%v\"", tt.val, got, tt.want)
+		}
+	}
+}
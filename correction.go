@@ -0,0 +1,82 @@
+package main
+
+//модель чека коррекции, не зависящая от драйвера ККТ. generateCheckCorrection заполняет её по данным ОФД,
+//а задание в формате конкретного драйвера формирует writer из writers.go.
+//Значения перечислений хранятся в обозначениях, принятых в программе: тип чека (sellCorrection ...),
+//СНО (osn, usnIncome ...), ставка НДС (vat20, none ...), способ и предмет расчёта (fullPayment, commodity ...),
+//единица измерения (piece, kilogram ...), тип оплаты (cash, electronically, prepaid, credit, other)
+
+type TCorrection struct {
+	Type                 string //sellCorrection, buyCorrection, sellReturnCorrection, buyReturnCorrection
+	Electronically       bool
+	TaxationType         string
+	INN                  string //ИНН организации из данных ОФД
+	ClientInfo           TClientInfo
+	CorrectionType       string //self - самостоятельно, instruction - по предписанию
+	CorrectionBaseDate   string //ГГГГ.ММ.ДД
+	CorrectionBaseNumber string
+	CorrectionReason     string //описание коррекции (тег 1177 чека коррекции ФФД 1.05)
+	Operator             TOperator
+	AdditionalAttribute  string                     //тег 1192
+	UserAttributes       []TCorrectionUserAttribute //тег 1084 (печатаются в порядке добавления)
+	Positions            []TCorrectionPosition
+	Payments             []TPayment
+	Total                float64          //сумма позиций
+	Taxes                []TCorrectionTax //итоги по ставкам НДС (суммы НДС из выгрузки ОФД, если они там есть)
+	IndustryInfo         []TIndustryInfo  //отраслевые реквизиты чека (тег 1261)
+	Descr                string           //описание исходного чека для логов (ФД, ФП, дата)
+}
+
+// итоги позиций по ставке НДС
+type TCorrectionTax struct {
+	Type   string
+	Sum    float64 //сумма НДС
+	Amount float64 //сумма расчёта по ставке
+}
+
+type TCorrectionUserAttribute struct {
+	Name  string
+	Value string
+}
+
+type TCorrectionPosition struct {
+	Name            string
+	Price           float64
+	Quantity        float64
+	Amount          float64
+	MeasurementUnit string
+	PaymentMethod   string
+	PaymentObject   string
+	Tax             string
+	Mark            string      //код маркировки или код товара в том виде, в котором он есть в данных ОФД
+	MarkType        string      //тип кода из данных ОФД (GS_1M, EAN_13, Undefined ...)
+	AgentInfo       *TAgentInfo //признак агента (тег 1222) и данные платёжного агента и оператора перевода
+	SupplierInfo    *TSupplierInfo
+	IndustryInfo    []TIndustryInfo //отраслевые реквизиты предмета расчёта (тег 1260)
+}
+
+// isProductCodeOfMark - код позиции является кодом товара (тег 1162/1163 без проверки КМ), а не кодом маркировки
+func isProductCodeOfMark(markType string) bool {
+	return (markType == "Undefined") || (markType == "EAN_8") || (markType == "EAN_13") || (markType == "ITF_14")
+}
+
+// getTaxesOfCorrection - суммы НДС и суммы расчёта по ставкам в порядке первого появления ставки в позициях
+func getTaxesOfCorrection(checkCorr TCorrection) []TCorrectionTax {
+	var res []TCorrectionTax
+	numbOfTax := make(map[string]int)
+	for _, pos := range checkCorr.Positions {
+		i, ok := numbOfTax[pos.Tax]
+		if !ok {
+			i = len(res)
+			numbOfTax[pos.Tax] = i
+			res = append(res, TCorrectionTax{Type: pos.Tax})
+		}
+		res[i].Sum += getSummNDS(pos.Amount, pos.Tax)
+		res[i].Amount += pos.Amount
+	}
+	for i := range res {
+		res[i].Sum = roundKopecks(res[i].Sum)
+		res[i].Amount = roundKopecks(res[i].Amount)
+	}
+	return res
+}
@@ -0,0 +1,140 @@
+package main
+
+//плоская таблица входных данных (layout = "flat" шаблона ОФД в [[template.ofd]]): один файл, каждая строка которого -
+//позиция чека с полями шапки. Строки одного чека определяются ключом groupkey (поля шаблона, например ФН и ФД
+//или ФН, номер смены и номер чека в смене) и могут идти в файле не подряд. Поля шапки берутся из всех строк чека
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const LAYOUTFLAT = "flat"
+
+// ключ чека плоской таблицы по умолчанию
+var defaultFlatGroupKey = []string{COLFNKKT, COLFD}
+
+// чек плоской таблицы: строка с полями шапки, собранная из всех строк чека, позиции и суммы оплат по позициям
+type TFlatCheck struct {
+	Line           []string
+	Positions      map[int]map[string]string
+	SummsOfPayment map[string]float64
+}
+
+var FlatLayout bool
+var FlatFileName string
+var FlatGroupKey []string
+
+// initLayout - чтение вида входных данных выбранного шаблона ОФД: layout, file (имя файла без .csv) и groupkey
+func initLayout(data map[string]interface{}) error {
+	FlatLayout = false
+	FlatFileName = "checks_header"
+	FlatGroupKey = defaultFlatGroupKey
+	ofdsinit, _ := data["template"].(map[string]interface{})["ofd"].([]map[string]interface{})
+	for _, ofdinit := range ofdsinit {
+		if fmt.Sprint(ofdinit["name"]) != OFD {
+			continue
+		}
+		if layout, ok := ofdinit["layout"]; ok {
+			if fmt.Sprint(layout) != LAYOUTFLAT {
+				return fmt.Errorf("неизвестный вид входных данных layout = \"%v\" шаблона %v (допустим %v)", layout, OFD, LAYOUTFLAT)
+			}
+			FlatLayout = true
+		}
+		if file, ok := ofdinit["file"]; ok {
+			FlatFileName = strings.TrimSuffix(strings.TrimSpace(fmt.Sprint(file)), ".csv")
+		}
+		if groupkey, ok := ofdinit["groupkey"].([]interface{}); ok {
+			FlatGroupKey = nil
+			for _, field := range groupkey {
+				FlatGroupKey = append(FlatGroupKey, strings.TrimSpace(fmt.Sprint(field)))
+			}
+		}
+	}
+	if !FlatLayout {
+		return nil
+	}
+	if len(FlatGroupKey) == 0 {
+		return errors.New("не задан ключ чека groupkey для плоской таблицы")
+	}
+	for _, field := range FlatGroupKey {
+		if FieldsNames[field] == "" || isInvField(FieldsNames[field]) {
+			return fmt.Errorf("поле ключа чека %v не задано в шаблоне %v", field, OFD)
+		}
+	}
+	return nil
+}
+
+// getKeyOfFlatLine - ключ чека строки плоской таблицы. Пустая строка - в строке не заполнено ни одно поле ключа
+func getKeyOfFlatLine(line []string, fieldsnums map[string]int) string {
+	var parts []string
+	empty := true
+	for _, field := range FlatGroupKey {
+		val := strings.TrimLeft(strings.TrimSpace(getfieldval(line, fieldsnums, field)), "0")
+		if val != "" {
+			empty = false
+		}
+		parts = append(parts, val)
+	}
+	if empty {
+		return ""
+	}
+	return strings.Join(parts, "_")
+}
+
+// getMaxNumOfField - наибольший номер столбца (с 0), используемый полями шаблона
+func getMaxNumOfField(fieldsnums map[string]int) int {
+	res := -1
+	for _, num := range fieldsnums {
+		res = max(res, num)
+	}
+	return res
+}
+
+// groupFlatLines - чеки плоской таблицы по номеру (с 1) первой строки чека в файле. firstLine - число строк до данных.
+// Строки, короче последнего столбца шаблона, пропускаются. Пустые ячейки строки шапки заполняются из других строк чека,
+// разные значения полей шапки записываются в лог ошибок
+func groupFlatLines(lines [][]string, firstLine int, fieldsnames map[string]string, fieldsnums map[string]int) map[int]TFlatCheck {
+	res := make(map[int]TFlatCheck)
+	numLineOfKey := make(map[string]int)
+	maxNumOfField := getMaxNumOfField(fieldsnums)
+	for i := firstLine; i < len(lines); i++ {
+		line := lines[i]
+		if len(line) <= maxNumOfField {
+			logsmap[LOGERROR].Printf("строка №%v \"%v\" пропущена, так как в ней %v столбцов, а в шаблоне используется столбец №%v", i+1, line, len(line), maxNumOfField+1)
+			continue
+		}
+		key := getKeyOfFlatLine(line, fieldsnums)
+		if key == "" {
+			logsmap[LOGERROR].Printf("строка №%v \"%v\" пропущена, так как в ней не заполнен ключ чека %v", i+1, line, FlatGroupKey)
+			continue
+		}
+		numLine, ok := numLineOfKey[key]
+		if !ok {
+			numLine = i + 1
+			numLineOfKey[key] = numLine
+			res[numLine] = TFlatCheck{Line: append([]string(nil), line...), Positions: make(map[int]map[string]string),
+				SummsOfPayment: make(map[string]float64)}
+		}
+		check := res[numLine]
+		for _, field := range AllFieldsHeadOfCheck {
+			num, ok := fieldsnums[field]
+			if !ok || isInvField(fieldsnames[field]) || num >= len(line) || num >= len(check.Line) {
+				continue
+			}
+			val := strings.TrimSpace(line[num])
+			if val == "" {
+				continue
+			}
+			if strings.TrimSpace(check.Line[num]) == "" {
+				check.Line[num] = line[num]
+			} else if strings.TrimSpace(check.Line[num]) != val {
+				logsmap[LOGERROR].Printf("в строке №%v чека %v значение поля %v \"%v\" отличается от \"%v\" в первой строке чека, используется первое",
+					i+1, key, field, val, check.Line[num])
+			}
+		}
+		check.Positions[len(check.Positions)+1] = getPositionOfLine(line, fieldsnames, fieldsnums, check.SummsOfPayment)
+		res[numLine] = check
+	}
+	return res
+}
@@ -0,0 +1,162 @@
+package main
+
+//отраслевые реквизиты предмета расчёта (тег 1260) и чека (тег 1261): идентификатор ФОИВ (тег 1262), дата (тег 1263)
+//и номер (тег 1264) документа основания, значение реквизита (тег 1265). Берутся из полей шаблона, а если их нет -
+//из таблицы [[industry]] файла init.toml по предмету расчёта и товарной группе позиции
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const INDUSTRYLEVELPOS = "position"
+const INDUSTRYLEVELCHECK = "check"
+
+// отраслевой реквизит по умолчанию из раздела [[industry]]
+type TIndustryDefault struct {
+	Descr         string
+	Level         string //position - тег 1260 позиции, check - тег 1261 чека
+	PaymentObject string //предмет расчёта позиции в обозначениях атол, пусто - любой
+	ProductGroup  string //товарная группа позиции (поле productgroup), пусто - любая
+	Info          TIndustryInfo
+}
+
+// предмет расчёта и товарная группа позиции для выбора отраслевых реквизитов чека
+type TIndustryKey struct {
+	PaymentObject string
+	ProductGroup  string
+}
+
+var IndustryDefaults []TIndustryDefault
+
+var regexpFois = regexp.MustCompile(`^\d{1,3}$`)
+
+// getIndustryInfo - проверенный отраслевой реквизит: ФОИВ дополняется нулями до 3 цифр, дата приводится к ДД.ММ.ГГГГ
+func getIndustryInfo(fois, date, number, value string) (TIndustryInfo, error) {
+	var res TIndustryInfo
+	fois, number, value = strings.TrimSpace(fois), strings.TrimSpace(number), strings.TrimSpace(value)
+	if !regexpFois.MatchString(fois) {
+		return res, fmt.Errorf("неверный идентификатор ФОИВ (тег 1262) \"%v\"", fois)
+	}
+	res.Fois = strings.Repeat("0", 3-len(fois)) + fois
+	for _, layout := range []string{"02.01.2006", "2006-01-02", "2006.01.02"} {
+		if dateOfDoc, err := time.Parse(layout, strings.TrimSpace(date)); err == nil {
+			res.Date = dateOfDoc.Format("02.01.2006")
+			break
+		}
+	}
+	if res.Date == "" {
+		return res, fmt.Errorf("неверная дата документа основания (тег 1263) \"%v\"", date)
+	}
+	if number == "" || value == "" {
+		return res, errors.New("не указаны номер документа основания (тег 1264) или значение отраслевого реквизита (тег 1265)")
+	}
+	res.Number = number
+	res.IndustryAttribute = value
+	return res, nil
+}
+
+// getIndustryInfoOfFields - отраслевой реквизит из полей шаблона. Второе значение - заполнено ли хотя бы одно поле
+func getIndustryInfoOfFields(fields map[string]string, colFois, colDate, colNumber, colValue string) (TIndustryInfo, bool, error) {
+	if fields[colFois] == "" && fields[colDate] == "" && fields[colNumber] == "" && fields[colValue] == "" {
+		return TIndustryInfo{}, false, nil
+	}
+	res, err := getIndustryInfo(fields[colFois], fields[colDate], fields[colNumber], fields[colValue])
+	return res, true, err
+}
+
+// initIndustryDefaults - чтение таблицы [[industry]] отраслевых реквизитов по умолчанию
+func initIndustryDefaults(data map[string]interface{}) error {
+	IndustryDefaults = nil
+	industryinit, _ := data["industry"].([]map[string]interface{})
+	for i, rowinit := range industryinit {
+		row := TIndustryDefault{Level: INDUSTRYLEVELPOS}
+		valOf := func(key string) string {
+			switch val := rowinit[key].(type) {
+			case nil:
+				return ""
+			case time.Time:
+				return val.Format("02.01.2006")
+			default:
+				return strings.TrimSpace(fmt.Sprint(val))
+			}
+		}
+		row.Descr = valOf("descr")
+		if row.Descr == "" {
+			row.Descr = fmt.Sprintf("строка №%v", i+1)
+		}
+		if level := strings.ToLower(valOf("level")); level != "" {
+			if level != INDUSTRYLEVELPOS && level != INDUSTRYLEVELCHECK {
+				return fmt.Errorf("неверный уровень level = \"%v\" отраслевого реквизита \"%v\" (допустимы %v, %v)", level, row.Descr, INDUSTRYLEVELPOS, INDUSTRYLEVELCHECK)
+			}
+			row.Level = level
+		}
+		if paymentObject := valOf("paymentobject"); paymentObject != "" {
+			//значение в обозначениях атол (commodityWithMarking и т.п.) или как в данных ОФД (ТМ, услуга ...)
+			row.PaymentObject = paymentObject
+			if _, ok := shtrihPaymentObjects[paymentObject]; !ok {
+				row.PaymentObject = getPredmRasch(paymentObject)
+			}
+		}
+		row.ProductGroup = strings.ToLower(valOf("productgroup"))
+		info, err := getIndustryInfo(valOf("fois"), valOf("date"), valOf("number"), valOf("value"))
+		if err != nil {
+			return fmt.Errorf("отраслевой реквизит \"%v\": %v", row.Descr, err)
+		}
+		row.Info = info
+		IndustryDefaults = append(IndustryDefaults, row)
+	}
+	logginInFile(fmt.Sprintf("прочитано %v отраслевых реквизитов по умолчанию", len(IndustryDefaults)))
+	return nil
+}
+
+func (row TIndustryDefault) isMatch(key TIndustryKey) bool {
+	return (row.PaymentObject == "" || row.PaymentObject == key.PaymentObject) &&
+		(row.ProductGroup == "" || row.ProductGroup == strings.ToLower(strings.TrimSpace(key.ProductGroup)))
+}
+
+// getIndustryInfoOfPos - отраслевые реквизиты позиции (тег 1260): из полей шаблона или (withDefaults) все подходящие по умолчанию
+func getIndustryInfoOfPos(pos map[string]string, paymentObject string, withDefaults bool) ([]TIndustryInfo, error) {
+	info, exist, err := getIndustryInfoOfFields(pos, COLINDUSTRYFOIS, COLINDUSTRYDATE, COLINDUSTRYNUMBER, COLINDUSTRYVALUE)
+	if err != nil || exist {
+		return []TIndustryInfo{info}, err
+	}
+	if !withDefaults {
+		return nil, nil
+	}
+	var res []TIndustryInfo
+	key := TIndustryKey{PaymentObject: paymentObject, ProductGroup: pos[COLPRODUCTGROUP]}
+	for _, row := range IndustryDefaults {
+		if row.Level == INDUSTRYLEVELPOS && row.isMatch(key) {
+			res = append(res, row.Info)
+		}
+	}
+	return res, nil
+}
+
+// getIndustryInfoOfCheck - отраслевые реквизиты чека (тег 1261): из полей шапки или (withDefaults) по умолчанию,
+// если под условие строки таблицы подходит хотя бы одна позиция чека
+func getIndustryInfoOfCheck(headofcheck map[string]string, keysOfPoss []TIndustryKey, withDefaults bool) ([]TIndustryInfo, error) {
+	info, exist, err := getIndustryInfoOfFields(headofcheck, COLINDUSTRYFOISCHECK, COLINDUSTRYDATECHECK, COLINDUSTRYNUMBERCHECK, COLINDUSTRYVALUECHECK)
+	if err != nil || exist {
+		return []TIndustryInfo{info}, err
+	}
+	if !withDefaults {
+		return nil, nil
+	}
+	var res []TIndustryInfo
+	for _, row := range IndustryDefaults {
+		if row.Level != INDUSTRYLEVELCHECK {
+			continue
+		}
+		for _, key := range keysOfPoss {
+			if row.isMatch(key) {
+				res = append(res, row.Info)
+				break
+			}
+		}
+	}
+	return res, nil
+}
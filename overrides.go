@@ -0,0 +1,219 @@
+package main
+
+//ручные исправления отдельных чеков из файла infiles/overrides.csv или infiles/overrides.toml
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+const FILEOVERRIDESCSV = "overrides.csv"
+const FILEOVERRIDESTOML = "overrides.toml"
+
+// поля исправлений, не являющиеся колонками шаблона ОФД
+const OVERRIDESNO = "sno"
+const OVERRIDENDS = "nds"
+
+type TOverride struct {
+	FN       string
+	FD       string
+	Smena    string
+	NumCheck string
+	Pos      int //номер позиции чека, 0 - исправление шапки чека
+	Field    string
+	Value    string
+}
+
+// исправление в файле overrides.toml
+type TOverrideToml struct {
+	FN       string            `toml:"fn"`
+	FD       string            `toml:"fd"`
+	Smena    string            `toml:"smena"`
+	NumCheck string            `toml:"numcheck"`
+	Pos      int               `toml:"pos"`
+	Set      map[string]string `toml:"set"`
+}
+
+// исправления по ключу ФН+ФД или ФН+смена+номер чека в смене
+var OverridesOfChecks map[string][]TOverride
+
+func trimNumberOfOverride(val string) string {
+	res := strings.TrimLeft(strings.TrimSpace(val), "0")
+	if res == "" && strings.TrimSpace(val) != "" {
+		res = "0"
+	}
+	return res
+}
+
+func getKeysOfOverride(fn, fd, smena, numcheck string) (string, string) {
+	fn = strings.TrimSpace(fn)
+	keyFD := ""
+	if strings.TrimSpace(fd) != "" {
+		keyFD = "fd_" + fn + "_" + trimNumberOfOverride(fd)
+	}
+	keySmena := ""
+	if strings.TrimSpace(smena) != "" && strings.TrimSpace(numcheck) != "" {
+		keySmena = "sm_" + fn + "_" + trimNumberOfOverride(smena) + "_" + trimNumberOfOverride(numcheck)
+	}
+	return keyFD, keySmena
+}
+
+// checkOverride - проверка поля и значения исправления
+func checkOverride(ovr TOverride) error {
+	if ovr.FN == "" || (ovr.FD == "" && (ovr.Smena == "" || ovr.NumCheck == "")) {
+		return fmt.Errorf("для исправления поля %v нужно указать ФН и ФД или ФН, номер смены и номер чека в смене", ovr.Field)
+	}
+	switch ovr.Field {
+	case OVERRIDESNO:
+		if getOsnFromChernovVal(ovr.Value) == "" {
+			return fmt.Errorf("не удалось определить систему налогообложения \"%v\"", ovr.Value)
+		}
+		return nil
+	case OVERRIDENDS:
+		if getStavkaNDSFromStr(ovr.Value) == "" {
+			return fmt.Errorf("не удалось определить ставку НДС \"%v\"", ovr.Value)
+		}
+		return nil
+	case EMAILFIELD, NOPRINTFIELD:
+		return nil
+	}
+	if slices.Contains(PaymentsFields, ovr.Field) {
+		if _, _, err := getFloatFromStr(ovr.Value); err != nil {
+			return fmt.Errorf("неверная сумма оплаты %v = \"%v\"", ovr.Field, ovr.Value)
+		}
+		return nil
+	}
+	if !slices.Contains(AllFieldsHeadOfCheck, ovr.Field) && !slices.Contains(AllFieldPositionsOfCheck, ovr.Field) {
+		return fmt.Errorf("неизвестное поле \"%v\"", ovr.Field)
+	}
+	return nil
+}
+
+func addOverride(ovr TOverride) error {
+	ovr.Field = strings.TrimSpace(ovr.Field)
+	ovr.Value = strings.TrimSpace(ovr.Value)
+	if slices.Contains(PaymentsFields, ovr.Field) {
+		ovr.Value = strings.ReplaceAll(ovr.Value, ",", ".")
+	}
+	if err := checkOverride(ovr); err != nil {
+		return err
+	}
+	keyFD, keySmena := getKeysOfOverride(ovr.FN, ovr.FD, ovr.Smena, ovr.NumCheck)
+	key := keyFD
+	if key == "" {
+		key = keySmena
+	}
+	OverridesOfChecks[key] = append(OverridesOfChecks[key], ovr)
+	return nil
+}
+
+// initOverrides - чтение необязательных файлов исправлений чеков.
+// overrides.csv: колонки fn;fd;smena;numcheck;pos;field;value, первая строка - заголовок
+func initOverrides() error {
+	OverridesOfChecks = make(map[string][]TOverride)
+	fullnameoffile := DIRINFILES + FILEOVERRIDESCSV
+	if existfile, _ := doesFileExist(fullnameoffile); existfile {
+		f, err := os.Open(fullnameoffile)
+		if err != nil {
+			return fmt.Errorf("не удалось (%v) открыть файл %v", err, fullnameoffile)
+		}
+		defer f.Close()
+		csv_red := csv.NewReader(f)
+		csv_red.FieldsPerRecord = -1
+		csv_red.LazyQuotes = true
+		csv_red.Comma = ';'
+		lines, err := csv_red.ReadAll()
+		if err != nil {
+			return fmt.Errorf("не удалось (%v) прочитать файл %v", err, fullnameoffile)
+		}
+		for numLine, line := range lines {
+			if numLine == 0 || strings.TrimSpace(strings.Join(line, "")) == "" {
+				continue
+			}
+			if len(line) < 7 {
+				return fmt.Errorf("в строке %v файла %v должно быть 7 колонок: fn;fd;smena;numcheck;pos;field;value", numLine+1, fullnameoffile)
+			}
+			ovr := TOverride{FN: strings.TrimSpace(line[0]), FD: strings.TrimSpace(line[1]), Smena: strings.TrimSpace(line[2]),
+				NumCheck: strings.TrimSpace(line[3]), Field: line[5], Value: line[6]}
+			if strings.TrimSpace(line[4]) != "" {
+				ovr.Pos, err = strconv.Atoi(strings.TrimSpace(line[4]))
+				if err != nil {
+					return fmt.Errorf("неверный номер позиции \"%v\" в строке %v файла %v", line[4], numLine+1, fullnameoffile)
+				}
+			}
+			if err := addOverride(ovr); err != nil {
+				return fmt.Errorf("%v в строке %v файла %v", err, numLine+1, fullnameoffile)
+			}
+		}
+	}
+	fullnameoffile = DIRINFILES + FILEOVERRIDESTOML
+	if existfile, _ := doesFileExist(fullnameoffile); existfile {
+		var overridesToml struct {
+			Overrides []TOverrideToml `toml:"overrides"`
+		}
+		if _, err := toml.DecodeFile(fullnameoffile, &overridesToml); err != nil {
+			return fmt.Errorf("не удалось (%v) прочитать файл %v", err, fullnameoffile)
+		}
+		for i, ovrToml := range overridesToml.Overrides {
+			for field, val := range ovrToml.Set {
+				ovr := TOverride{FN: ovrToml.FN, FD: ovrToml.FD, Smena: ovrToml.Smena, NumCheck: ovrToml.NumCheck,
+					Pos: ovrToml.Pos, Field: field, Value: val}
+				if err := addOverride(ovr); err != nil {
+					return fmt.Errorf("%v в исправлении №%v файла %v", err, i+1, fullnameoffile)
+				}
+			}
+		}
+	}
+	logginInFile(fmt.Sprintf("прочитаны исправления для %v чеков", len(OverridesOfChecks)))
+	return nil
+}
+
+// applyOverrides - применение исправлений к шапке, позициям и суммам оплат чека.
+// Каждое исправление записывается в отчёт. Возвращает true, если были исправлены суммы оплат
+func applyOverrides(headofcheck map[string]string, poss map[int]map[string]string, summsOfPayment map[string]float64, checkDescrInfo string) bool {
+	paymentsOverridden := false
+	keyFD, keySmena := getKeysOfOverride(headofcheck[COLFNKKT], headofcheck[COLFD], headofcheck[COLNUMSM], headofcheck[COLNUMCHECKSMENA])
+	var overrides []TOverride
+	if keyFD != "" {
+		overrides = append(overrides, OverridesOfChecks[keyFD]...)
+	}
+	if keySmena != "" {
+		overrides = append(overrides, OverridesOfChecks[keySmena]...)
+	}
+	for _, ovr := range overrides {
+		if ovr.Pos == 0 && (ovr.Field != OVERRIDENDS) && !slices.Contains(AllFieldPositionsOfCheck, ovr.Field) {
+			val := ovr.Value
+			field := ovr.Field
+			if field == OVERRIDESNO {
+				field, val = FORCEDSNOFIELD, getOsnFromChernovVal(ovr.Value)
+			}
+			if slices.Contains(PaymentsFields, field) {
+				summsOfPayment[field], _, _ = getFloatFromStr(val)
+				paymentsOverridden = true
+			}
+			logsmap[LOGREPORT].Printf("чек %v: по файлу исправлений поле %v изменено с \"%v\" на \"%v\"", checkDescrInfo, ovr.Field, headofcheck[field], val)
+			headofcheck[field] = val
+			continue
+		}
+		field, val := ovr.Field, ovr.Value
+		if field == OVERRIDENDS {
+			field, val = FORCEDNDSFIELD, getStavkaNDSFromStr(ovr.Value)
+		}
+		for numPos, pos := range poss {
+			if ovr.Pos != 0 && ovr.Pos != numPos {
+				continue
+			}
+			logsmap[LOGREPORT].Printf("чек %v: для позиции %v \"%v\" по файлу исправлений поле %v изменено с \"%v\" на \"%v\"", checkDescrInfo, numPos, pos[COLNAME], ovr.Field, pos[field], val)
+			pos[field] = val
+		}
+		if _, ok := poss[ovr.Pos]; ovr.Pos != 0 && !ok {
+			logsmap[LOGREPORT].Printf("чек %v: в файле исправлений указана позиция %v, которой нет в чеке (поле %v)", checkDescrInfo, ovr.Pos, ovr.Field)
+		}
+	}
+	return paymentsOverridden
+}
@@ -0,0 +1,254 @@
+package main
+
+//разрешение ошибок в суммах оплат чека без участия оператора
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+)
+
+const FILEPAYMENTSOVERRIDE = "payments_override.csv"
+
+// стратегии разрешения ошибок в суммах оплат (флаг -paymentstrategy)
+const PAYMENTSTRATEGYINTERACTIVE = "interactive"
+const PAYMENTSTRATEGYCASH = "cash"
+const PAYMENTSTRATEGYCARD = "card"
+const PAYMENTSTRATEGYPROPORTIONAL = "proportional"
+const PAYMENTSTRATEGYHEAD = "head"
+const PAYMENTSTRATEGYPOSITIONS = "positions"
+const PAYMENTSTRATEGYQUARANTINE = "quarantine"
+
+var PaymentStrategies = []string{PAYMENTSTRATEGYINTERACTIVE, PAYMENTSTRATEGYCASH, PAYMENTSTRATEGYCARD,
+	PAYMENTSTRATEGYPROPORTIONAL, PAYMENTSTRATEGYHEAD, PAYMENTSTRATEGYPOSITIONS, PAYMENTSTRATEGYQUARANTINE}
+
+// поля сумм оплат чека в порядке их вывода и ввода
+var PaymentsFields = []string{COLNAL, COLBEZ, COLAVANCE, COLCREDIT, COLVSTRECHPREDST}
+
+// суммы оплат, заданные вручную в файле payments_override.csv, по ключу ФН+ФД
+var PaymentsOverrides map[string]map[string]float64
+
+// initPaymentStrategy - проверка флага -paymentstrategy и чтение файла переопределения сумм оплат
+func initPaymentStrategy() error {
+	if !slices.Contains(PaymentStrategies, *paymentStrategy) {
+		return fmt.Errorf("неизвестная стратегия %v разрешения ошибок в суммах оплат (допустимые значения: %v)", *paymentStrategy, strings.Join(PaymentStrategies, ", "))
+	}
+	return loadPaymentsOverrides()
+}
+
+func getKeyOfPaymentsOverride(fn, fd string) string {
+	fd = strings.TrimLeft(strings.TrimSpace(fd), "0")
+	return strings.TrimSpace(fn) + "_" + fd
+}
+
+// loadPaymentsOverrides - чтение необязательного файла payments_override.csv.
+// Колонки: fn;fd;nal;bez;avance;credit;vstrechpredst, первая строка - заголовок
+func loadPaymentsOverrides() error {
+	PaymentsOverrides = make(map[string]map[string]float64)
+	fullnameoffile := DIRINFILES + FILEPAYMENTSOVERRIDE
+	if existfile, _ := doesFileExist(fullnameoffile); !existfile {
+		return nil
+	}
+	f, err := os.Open(fullnameoffile)
+	if err != nil {
+		return fmt.Errorf("не удалось (%v) открыть файл %v", err, fullnameoffile)
+	}
+	defer f.Close()
+	csv_red := csv.NewReader(f)
+	csv_red.FieldsPerRecord = -1
+	csv_red.LazyQuotes = true
+	csv_red.Comma = ';'
+	lines, err := csv_red.ReadAll()
+	if err != nil {
+		return fmt.Errorf("не удалось (%v) прочитать файл %v", err, fullnameoffile)
+	}
+	for numLine, line := range lines {
+		if numLine == 0 {
+			continue
+		}
+		if strings.TrimSpace(strings.Join(line, "")) == "" {
+			continue
+		}
+		if len(line) < 2+len(PaymentsFields) {
+			return fmt.Errorf("в строке %v файла %v должно быть %v колонок: fn;fd;%v", numLine+1, fullnameoffile, 2+len(PaymentsFields), strings.Join(PaymentsFields, ";"))
+		}
+		payments := make(map[string]float64)
+		for i, field := range PaymentsFields {
+			valStr := strings.ReplaceAll(strings.TrimSpace(line[2+i]), ",", ".")
+			val, descrErr, err := getFloatFromStr(valStr)
+			if err != nil {
+				return fmt.Errorf("%v в строке %v файла %v", descrErr, numLine+1, fullnameoffile)
+			}
+			payments[field] = val
+		}
+		PaymentsOverrides[getKeyOfPaymentsOverride(line[0], line[1])] = payments
+	}
+	logginInFile(fmt.Sprintf("прочитано %v переопределений сумм оплат из файла %v", len(PaymentsOverrides), fullnameoffile))
+	return nil
+}
+
+// getPaymentsOverride - суммы оплат чека из файла payments_override.csv
+func getPaymentsOverride(fn, fd string) (map[string]float64, bool) {
+	payments, ok := PaymentsOverrides[getKeyOfPaymentsOverride(fn, fd)]
+	return payments, ok
+}
+
+// getPaymentsOfHead - суммы оплат из колонок шапки чека (колонки, которые находятся в таблице позиций, не учитываются)
+func getPaymentsOfHead(headofcheck map[string]string) map[string]float64 {
+	res := make(map[string]float64)
+	for _, field := range PaymentsFields {
+		if _, ok := FieldsNums[field]; !ok || isInvField(FieldsNames[field]) {
+			continue
+		}
+		if val, _, err := getFloatFromStr(headofcheck[field]); err == nil && val != 0 {
+			res[field] = val
+		}
+	}
+	return res
+}
+
+// existPaymentsInTemplate - есть ли в шаблоне ОФД хотя бы одна колонка сумм оплат
+func existPaymentsInTemplate() bool {
+	for _, field := range PaymentsFields {
+		if _, ok := FieldsNums[field]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func getTotalOfPayments(payments map[string]float64) float64 {
+	total := 0.0
+	for _, field := range PaymentsFields {
+		total += payments[field]
+	}
+	return total
+}
+
+func roundKopecks(summ float64) float64 {
+	return math.Round(summ*100) / 100
+}
+
+func descrOfPayments(payments map[string]float64) string {
+	res := fmt.Sprintf("наличными %v", payments[COLNAL])
+	res += fmt.Sprintf(", картой %v", payments[COLBEZ])
+	res += fmt.Sprintf(", кредитом %v", payments[COLCREDIT])
+	res += fmt.Sprintf(", зачётом аванса (предоплатой) %v", payments[COLAVANCE])
+	res += fmt.Sprintf(", встречным представлением %v", payments[COLVSTRECHPREDST])
+	return res
+}
+
+func replacePayments(summsOfPayment, newPayments map[string]float64) {
+	for _, field := range PaymentsFields {
+		summsOfPayment[field] = newPayments[field]
+	}
+}
+
+// resolveMistakeInPayments - исправление сумм оплат чека по выбранной стратегии.
+// Возвращает false, если чек нужно поместить в карантин (пропустить)
+func resolveMistakeInPayments(summsOfPayment, paymentsOfHead, paymentsOfPoss map[string]float64, amountOfCheck float64, checkDescrInfo string) bool {
+	oldDescr := descrOfPayments(summsOfPayment)
+	newPayments := make(map[string]float64)
+	for _, field := range PaymentsFields {
+		newPayments[field] = summsOfPayment[field]
+	}
+	switch *paymentStrategy {
+	case PAYMENTSTRATEGYINTERACTIVE:
+		enterPaymentsInteractively(summsOfPayment, amountOfCheck, checkDescrInfo)
+		logsmap[LOGREPORT].Printf("чек %v: суммы оплат введены вручную (были: %v, стали: %v)", checkDescrInfo, oldDescr, descrOfPayments(summsOfPayment))
+		return true
+	case PAYMENTSTRATEGYCASH, PAYMENTSTRATEGYCARD:
+		fieldOfRemainder := COLNAL
+		if *paymentStrategy == PAYMENTSTRATEGYCARD {
+			fieldOfRemainder = COLBEZ
+		}
+		remainder := roundKopecks(amountOfCheck - (getTotalOfPayments(newPayments) - newPayments[fieldOfRemainder]))
+		if remainder < 0 {
+			logsmap[LOGREPORT].Printf("чек %v: остаток суммы чека %v не может быть отнесён на оплату %v, другие оплаты больше суммы чека (%v)", checkDescrInfo, amountOfCheck, fieldOfRemainder, oldDescr)
+			return false
+		}
+		newPayments[fieldOfRemainder] = remainder
+	case PAYMENTSTRATEGYPROPORTIONAL:
+		total := getTotalOfPayments(newPayments)
+		if total <= 0 {
+			logsmap[LOGREPORT].Printf("чек %v: суммы оплат нулевые, пропорционально изменить их нельзя", checkDescrInfo)
+			return false
+		}
+		coef := amountOfCheck / total
+		fieldOfMax := COLNAL
+		totalNew := 0.0
+		for _, field := range PaymentsFields {
+			newPayments[field] = roundKopecks(newPayments[field] * coef)
+			totalNew += newPayments[field]
+			if newPayments[field] > newPayments[fieldOfMax] {
+				fieldOfMax = field
+			}
+		}
+		//копейки от округления относим на самую большую оплату
+		newPayments[fieldOfMax] = roundKopecks(newPayments[fieldOfMax] + amountOfCheck - totalNew)
+	case PAYMENTSTRATEGYHEAD, PAYMENTSTRATEGYPOSITIONS:
+		source, descrSource := paymentsOfHead, "шапке"
+		if *paymentStrategy == PAYMENTSTRATEGYPOSITIONS {
+			source, descrSource = paymentsOfPoss, "позициях"
+		}
+		if getTotalOfPayments(source) <= 0 {
+			logsmap[LOGREPORT].Printf("чек %v: в %v чека нет сумм оплат", checkDescrInfo, descrSource)
+			return false
+		}
+		for _, field := range PaymentsFields {
+			newPayments[field] = source[field]
+		}
+		if roundKopecks(getTotalOfPayments(newPayments)) != roundKopecks(amountOfCheck) {
+			logsmap[LOGREPORT].Printf("чек %v: сумма оплат %v в %v чека не равна сумме чека %v, оплаты не исправлены", checkDescrInfo, roundKopecks(getTotalOfPayments(newPayments)), descrSource, amountOfCheck)
+			return false
+		}
+	default:
+		return false
+	}
+	replacePayments(summsOfPayment, newPayments)
+	logsmap[LOGREPORT].Printf("чек %v: суммы оплат исправлены по стратегии %v (были: %v, стали: %v)", checkDescrInfo, *paymentStrategy, oldDescr, descrOfPayments(summsOfPayment))
+	return true
+}
+
+// enterPaymentsInteractively - ручной ввод сумм оплат чека оператором
+func enterPaymentsInteractively(summsOfPayment map[string]float64, amountOfCheck float64, checkDescrInfo string) {
+	deskMistPaym := fmt.Sprintf("Для чека %v не возможно определить сумму оплат. Сделаёте это вручную. И укажите суммы оплат далее...", checkDescrInfo)
+	summPaymentsCurrDescr := "Сейчас суммы оплат такие: " + descrOfPayments(summsOfPayment)
+	logginInFile(deskMistPaym)
+	logginInFile(summPaymentsCurrDescr)
+	fmt.Println(deskMistPaym)
+	fmt.Println(summPaymentsCurrDescr)
+	fmt.Printf("Сумма чека %v\n", amountOfCheck)
+	descrsOfPayments := map[string]string{
+		COLNAL:           "наличными",
+		COLBEZ:           "безналичными",
+		COLAVANCE:        "зачётом аванса (предоплатой)",
+		COLCREDIT:        "кредитом",
+		COLVSTRECHPREDST: "встречным представлением",
+	}
+	input := bufio.NewScanner(os.Stdin)
+	for _, field := range PaymentsFields {
+		fmt.Printf("Введите сумму оплаты %v (%v):\n", descrsOfPayments[field], summsOfPayment[field])
+		input.Scan()
+		valstr := input.Text()
+		if !notEmptyFloatField(valstr) {
+			continue
+		}
+		val, _, err := getFloatFromStr(valstr)
+		if err != nil {
+			logsmap[LOGERROR].Printf("ошибка (%v) парсинга строки %v для оплаты %v", err, valstr, descrsOfPayments[field])
+			continue
+		}
+		summsOfPayment[field] = val
+	}
+	summPaymentsCurrDescr = "Сейчас суммы оплат такие: " + descrOfPayments(summsOfPayment)
+	fmt.Println(summPaymentsCurrDescr)
+	logginInFile("суммы оплат были изменены")
+	logginInFile(deskMistPaym)
+	logginInFile(summPaymentsCurrDescr)
+	fmt.Printf("Нажмите любую клавишу для продолжения формирования json-заданий...")
+	input.Scan()
+}
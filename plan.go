@@ -0,0 +1,190 @@
+package main
+
+//план печати заданий по каждому ФН: открытие смены, очистка таблицы результатов проверки марок,
+//чеки коррекции, закрытие смены и манифест с порядком выполнения заданий
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+const DIRPRINTPLAN = "plan/"
+const FILEMANIFEST = "manifest.json"
+
+// резерв времени смены (в секундах) на открытие и закрытие смены
+const RESERVEOFSHIFTSECONDS = 600
+const MAXSHIFTSECONDS = 24 * 3600
+
+// задание атол, не являющееся чеком
+type TShiftTask struct {
+	Type     string     `json:"type"` //openShift, closeShift, clearMarkingCodeValidationResult
+	Operator *TOperator `json:"operator,omitempty"`
+}
+
+// чек коррекции, записанный в папку ФН
+type TPlanReceipt struct {
+	FileName   string
+	NumOfCheck int //номер ФД чека
+	CheckCorr  TCorrectionCheck
+}
+
+type TManifestTask struct {
+	Order      int                `json:"order"`
+	File       string             `json:"file"`
+	Type       string             `json:"type"`
+	Shift      int                `json:"shift"`
+	SourceFile string             `json:"sourceFile,omitempty"`
+	Total      float64            `json:"total,omitempty"`
+	Payments   map[string]float64 `json:"payments,omitempty"`
+}
+
+type TManifest struct {
+	FN               string             `json:"fn"`
+	CountOfReceipts  int                `json:"countOfReceipts"`
+	CountOfShifts    int                `json:"countOfShifts"`
+	MaxChecksInShift int                `json:"maxChecksInShift"`
+	Totals           map[string]float64 `json:"totals"`   //суммы чеков по типам коррекции
+	Payments         map[string]float64 `json:"payments"` //суммы оплат по типам оплат
+	Tasks            []TManifestTask    `json:"tasks"`
+}
+
+// чеки коррекции по номеру ФН для плана печати
+var PrintPlanReceipts = make(map[string][]TPlanReceipt)
+
+// addReceiptToPrintPlan - запоминание записанного задания чека для плана печати
+func addReceiptToPrintPlan(fn, fileName string, numOfCheck int, checkCorr TCorrectionCheck) {
+	if !*printPlan {
+		return
+	}
+	PrintPlanReceipts[fn] = append(PrintPlanReceipts[fn], TPlanReceipt{FileName: fileName, NumOfCheck: numOfCheck, CheckCorr: checkCorr})
+}
+
+// getMaxChecksInShift - наибольшее число чеков в смене с учётом флага -maxchecksinshift
+// и ограничения смены 24 часами (по оценке времени печати одного чека -secondspercheck)
+func getMaxChecksInShift() int {
+	res := *maxChecksInShift
+	if *secondsPerCheck > 0 {
+		byTime := (MAXSHIFTSECONDS - RESERVEOFSHIFTSECONDS) / *secondsPerCheck
+		if byTime < 1 {
+			byTime = 1
+		}
+		if res <= 0 || byTime < res {
+			res = byTime
+		}
+	}
+	return res
+}
+
+func getTotalOfReceipt(checkCorr TCorrectionCheck) float64 {
+	total := 0.0
+	for _, item := range checkCorr.Items {
+		if pos, ok := item.(TPosition); ok {
+			total += pos.Amount
+		}
+	}
+	return roundKopecks(total)
+}
+
+func existMarksInReceipt(checkCorr TCorrectionCheck) bool {
+	for _, item := range checkCorr.Items {
+		if pos, ok := item.(TPosition); ok && pos.ImcParams != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func writeTaskOfPlan(dirOfPlan, fileName string, task interface{}) error {
+	as_json, err := json.MarshalIndent(task, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dirOfPlan+fileName, as_json, 0666)
+}
+
+// buildPrintPlans - формирование планов печати для всех ФН
+func buildPrintPlans() {
+	if !*printPlan {
+		return
+	}
+	maxInShift := getMaxChecksInShift()
+	for fn, receipts := range PrintPlanReceipts {
+		if err := buildPrintPlanOfFN(fn, receipts, maxInShift); err != nil {
+			logsmap[LOGERROR].Printf("ошибка (%v) формирования плана печати для ФН %v", err, fn)
+			continue
+		}
+		logsmap[LOGINFO_WITHSTD].Printf("для ФН %v сформирован план печати %v%v/%v", fn, JSONRES, fn, DIRPRINTPLAN)
+	}
+}
+
+func buildPrintPlanOfFN(fn string, receipts []TPlanReceipt, maxInShift int) error {
+	dirOfPlan := fmt.Sprintf("%v%v/%v", JSONRES, fn, DIRPRINTPLAN)
+	if err := os.RemoveAll(dirOfPlan); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dirOfPlan, 0777); err != nil {
+		return err
+	}
+	//чеки идут по номеру ФД (число, а не строка имени файла: ФН_100 после ФН_99), задания одного чека
+	//режима -reissue - по имени файла, в котором обратная коррекция (1_reverse) идёт перед исправленным чеком (2_fixed)
+	sort.SliceStable(receipts, func(i, j int) bool {
+		if receipts[i].NumOfCheck != receipts[j].NumOfCheck {
+			return receipts[i].NumOfCheck < receipts[j].NumOfCheck
+		}
+		return receipts[i].FileName < receipts[j].FileName
+	})
+	manifest := TManifest{FN: fn, CountOfReceipts: len(receipts), MaxChecksInShift: maxInShift,
+		Totals: make(map[string]float64), Payments: make(map[string]float64)}
+	order := 0
+	shift := 0
+	addTask := func(fileName, typeOfTask, sourceFile string, task interface{}) (TManifestTask, error) {
+		order++
+		fileOfTask := fmt.Sprintf("%04d_%v", order, fileName)
+		manifestTask := TManifestTask{Order: order, File: fileOfTask, Type: typeOfTask, Shift: shift, SourceFile: sourceFile}
+		return manifestTask, writeTaskOfPlan(dirOfPlan, fileOfTask, task)
+	}
+	countInShift := 0
+	var operatorOfShift TOperator
+	for i, receipt := range receipts {
+		if countInShift == 0 {
+			shift++
+			operatorOfShift = receipt.CheckCorr.Operator
+			manifestTask, err := addTask("openShift.json", "openShift", "", TShiftTask{Type: "openShift", Operator: &operatorOfShift})
+			if err != nil {
+				return err
+			}
+			manifest.Tasks = append(manifest.Tasks, manifestTask)
+		}
+		if existMarksInReceipt(receipt.CheckCorr) {
+			manifestTask, err := addTask("clearMarkingCodeValidationResult.json", "clearMarkingCodeValidationResult", "", TShiftTask{Type: "clearMarkingCodeValidationResult"})
+			if err != nil {
+				return err
+			}
+			manifest.Tasks = append(manifest.Tasks, manifestTask)
+		}
+		manifestTask, err := addTask(receipt.FileName, receipt.CheckCorr.Type, receipt.FileName, receipt.CheckCorr)
+		if err != nil {
+			return err
+		}
+		manifestTask.Total = getTotalOfReceipt(receipt.CheckCorr)
+		manifestTask.Payments = make(map[string]float64)
+		for _, pay := range receipt.CheckCorr.Payments {
+			manifestTask.Payments[pay.Type] = roundKopecks(manifestTask.Payments[pay.Type] + pay.Sum)
+			manifest.Payments[pay.Type] = roundKopecks(manifest.Payments[pay.Type] + pay.Sum)
+		}
+		manifest.Totals[receipt.CheckCorr.Type] = roundKopecks(manifest.Totals[receipt.CheckCorr.Type] + manifestTask.Total)
+		manifest.Tasks = append(manifest.Tasks, manifestTask)
+		countInShift++
+		if (maxInShift > 0 && countInShift >= maxInShift) || i == len(receipts)-1 {
+			manifestTask, err := addTask("closeShift.json", "closeShift", "", TShiftTask{Type: "closeShift", Operator: &operatorOfShift})
+			if err != nil {
+				return err
+			}
+			manifest.Tasks = append(manifest.Tasks, manifestTask)
+			countInShift = 0
+		}
+	}
+	manifest.CountOfShifts = shift
+	return writeTaskOfPlan(dirOfPlan, FILEMANIFEST, manifest)
+}
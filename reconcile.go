@@ -0,0 +1,245 @@
+package main
+
+//команда reconcile: сопоставление напечатанных чеков коррекции с исходными чеками по тегу 1192
+//(ФП или ФД исходного чека, записанный в generateCheckCorrection)
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const COMMANDRECONCILE = "reconcile"
+
+// источники данных о напечатанных чеках коррекции (флаг -reconcilesource)
+const RECONCILESOURCEJOURNAL = "journal" //журналы printed.txt команды submit
+const RECONCILESOURCEEXPORT = "export"   //выгрузка чеков коррекции из ОФД infiles/corrections.csv по шаблону ОФД
+
+const COLTAG1192 = "tag1192"
+const FILECORRECTIONSEXPORT = "corrections.csv"
+const FILERECONCILE = "reconcile.csv"
+
+// статусы исходного чека
+const RECONCILECORRECTED = "corrected"
+const RECONCILEMISSING = "missing"
+const RECONCILEPARTIAL = "partial"
+const RECONCILEDUPLICATE = "duplicate"
+
+type TReconcileOriginal struct {
+	FN          string
+	FD          string
+	Attribute   string   //значение тега 1192 в задании
+	Files       []string //задания для исходного чека (в режиме -reissue их два)
+	Corrections []string //найденные чеки коррекции
+}
+
+func getKeyOfReconcile(fn, attribute string) string {
+	return strings.TrimSpace(fn) + "_" + strings.TrimLeft(strings.TrimSpace(attribute), "0")
+}
+
+// getAttributeOfTask - значение тега 1192 из файла задания (additionalAttribute задания атол
+// или additional_check_props документа АТОЛ Онлайн)
+func getAttributeOfTask(fullFileName string) (string, error) {
+	content, err := os.ReadFile(fullFileName)
+	if err != nil {
+		return "", err
+	}
+	var task struct {
+		Items    []TTag1192_91       `json:"items"`
+		Document TAtolOnlineDocument `json:"document"`
+	}
+	if err := json.Unmarshal(content, &task); err != nil {
+		return "", fmt.Errorf("ошибка (%v) разбора файла %v", err, fullFileName)
+	}
+	if task.Document.Receipt.AdditionalCheckProps != "" {
+		return task.Document.Receipt.AdditionalCheckProps, nil
+	}
+	for _, item := range task.Items {
+		if item.Type == "additionalAttribute" {
+			return item.Value, nil
+		}
+	}
+	return "", nil
+}
+
+// collectOriginalsOfReconcile - исходные чеки по заданиям в папках ФН (без плана печати)
+func collectOriginalsOfReconcile() (map[string]*TReconcileOriginal, error) {
+	res := make(map[string]*TReconcileOriginal)
+	dirs, err := os.ReadDir(JSONRES)
+	if err != nil {
+		return res, err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		dirOfFN := JSONRES + dir.Name() + "/"
+		files, err := os.ReadDir(dirOfFN)
+		if err != nil {
+			return res, err
+		}
+		for _, file := range files {
+			if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+				continue
+			}
+			attribute, err := getAttributeOfTask(dirOfFN + file.Name())
+			if err != nil {
+				logsmap[LOGERROR].Println(err)
+				continue
+			}
+			if attribute == "" {
+				logsmap[LOGERROR].Printf("в задании %v%v нет тега 1192, чек нельзя сопоставить", dirOfFN, file.Name())
+				continue
+			}
+			key := getKeyOfReconcile(dir.Name(), attribute)
+			if _, ok := res[key]; !ok {
+				fd := ""
+				if parts := regexpFNFDOfFile.FindStringSubmatch(file.Name()); parts != nil {
+					fd = parts[2]
+				}
+				res[key] = &TReconcileOriginal{FN: dir.Name(), FD: fd, Attribute: attribute}
+			}
+			res[key].Files = append(res[key].Files, file.Name())
+		}
+	}
+	return res, nil
+}
+
+// addCorrectionsFromJournals - чеки коррекции, выполненные командой submit
+func addCorrectionsFromJournals(originals map[string]*TReconcileOriginal, unknown *[]string) {
+	dirs, _ := os.ReadDir(JSONRES)
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		dirOfFN := JSONRES + dir.Name() + "/"
+		for fileOfTask, rec := range readSubmitJournal(dirOfFN) {
+			if rec.Status != SUBMITSTATUSREADY {
+				continue
+			}
+			attribute, err := getAttributeOfTask(dirOfFN + fileOfTask)
+			if err != nil || attribute == "" {
+				continue //задания открытия и закрытия смены
+			}
+			descrOfCorrection := fmt.Sprintf("ФД %v (%v)", rec.FD, fileOfTask)
+			if original, ok := originals[getKeyOfReconcile(dir.Name(), attribute)]; ok {
+				original.Corrections = append(original.Corrections, descrOfCorrection)
+			} else {
+				*unknown = append(*unknown, fmt.Sprintf("ФН %v: %v, тег 1192 = %v", dir.Name(), descrOfCorrection, attribute))
+			}
+		}
+	}
+}
+
+// addCorrectionsFromExport - чеки коррекции из выгрузки ОФД (колонки по шаблону выбранного ОФД, тег 1192 - поле tag1192)
+func addCorrectionsFromExport(originals map[string]*TReconcileOriginal, unknown *[]string) error {
+	fullFileName := DIRINFILES + FILECORRECTIONSEXPORT
+	f, err := os.Open(fullFileName)
+	if err != nil {
+		return fmt.Errorf("не удалось (%v) открыть файл %v", err, fullFileName)
+	}
+	defer f.Close()
+	csv_red := csv.NewReader(f)
+	csv_red.FieldsPerRecord = -1
+	csv_red.LazyQuotes = true
+	csv_red.Comma = ';'
+	lines, err := csv_red.ReadAll()
+	if err != nil {
+		return fmt.Errorf("не удалось (%v) прочитать файл %v", err, fullFileName)
+	}
+	currNumbLineOfHead := 0
+	for currNumbLineOfHead < len(lines) && strings.Trim(strings.Join(lines[currNumbLineOfHead], ""), "; ") == "" {
+		currNumbLineOfHead++
+	}
+	if currNumbLineOfHead >= len(lines) {
+		return nil
+	}
+	FieldsNums = getNumberOfFieldsInCSV(lines[currNumbLineOfHead], FieldsNames, FieldsNums, "head")
+	if _, ok := FieldsNums[COLTAG1192]; !ok {
+		return fmt.Errorf("в файле %v не найдена колонка тега 1192 (поле %v шаблона %v)", fullFileName, COLTAG1192, OFD)
+	}
+	for _, line := range lines[currNumbLineOfHead+1:] {
+		if len(line) < len(lines[currNumbLineOfHead]) {
+			continue
+		}
+		attribute := strings.TrimSpace(getfieldval(line, FieldsNums, COLTAG1192))
+		if attribute == "" {
+			continue
+		}
+		//в выгрузке могут быть и обычные чеки - берём только коррекции, если тип чека известен
+		typeOfCheck := strings.ToLower(getfieldval(line, FieldsNums, COLTYPECHECK) + " " + getfieldval(line, FieldsNums, COLTAG1054))
+		if strings.TrimSpace(typeOfCheck) != "" && !strings.Contains(typeOfCheck, "коррекц") {
+			continue
+		}
+		fn := strings.TrimSpace(getfieldval(line, FieldsNums, COLFNKKT))
+		descrOfCorrection := fmt.Sprintf("ФД %v", strings.TrimSpace(getfieldval(line, FieldsNums, COLFD)))
+		if original, ok := originals[getKeyOfReconcile(fn, attribute)]; ok {
+			original.Corrections = append(original.Corrections, descrOfCorrection)
+		} else {
+			*unknown = append(*unknown, fmt.Sprintf("ФН %v: %v, тег 1192 = %v", fn, descrOfCorrection, attribute))
+		}
+	}
+	return nil
+}
+
+func getStatusOfReconcile(original *TReconcileOriginal) string {
+	switch {
+	case len(original.Corrections) == 0:
+		return RECONCILEMISSING
+	case len(original.Corrections) < len(original.Files):
+		return RECONCILEPARTIAL
+	case len(original.Corrections) > len(original.Files):
+		return RECONCILEDUPLICATE
+	}
+	return RECONCILECORRECTED
+}
+
+// runReconcile - команда reconcile: запись json/reconcile.csv со статусом каждого исходного чека
+func runReconcile() {
+	originals, err := collectOriginalsOfReconcile()
+	if err != nil {
+		logsmap[LOGERROR].Printf("ошибка (%v) чтения заданий из папки %v", err, JSONRES)
+		return
+	}
+	var unknown []string
+	if *reconcileSource == RECONCILESOURCEEXPORT {
+		if err := addCorrectionsFromExport(originals, &unknown); err != nil {
+			logsmap[LOGERROR].Println(err)
+			return
+		}
+	} else {
+		addCorrectionsFromJournals(originals, &unknown)
+	}
+	keys := make([]string, 0, len(originals))
+	for key := range originals {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	f, err := os.Create(JSONRES + FILERECONCILE)
+	if err != nil {
+		logsmap[LOGERROR].Printf("ошибка (%v) создания файла %v%v", err, JSONRES, FILERECONCILE)
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, "fn;fd;tag1192;tasks;corrections;status;correctionchecks")
+	countsOfStatus := make(map[string]int)
+	for _, key := range keys {
+		original := originals[key]
+		status := getStatusOfReconcile(original)
+		countsOfStatus[status]++
+		fmt.Fprintf(f, "%v;%v;%v;%v;%v;%v;%v\n", original.FN, original.FD, original.Attribute, len(original.Files),
+			len(original.Corrections), status, strings.Join(original.Corrections, ", "))
+		if status != RECONCILECORRECTED {
+			logsmap[LOGREPORT].Printf("ФН %v ФД %v (тег 1192 = %v): %v, заданий %v, чеков коррекции %v %v", original.FN, original.FD,
+				original.Attribute, status, len(original.Files), len(original.Corrections), strings.Join(original.Corrections, ", "))
+		}
+	}
+	for _, descr := range unknown {
+		logsmap[LOGREPORT].Printf("чек коррекции не соответствует ни одному заданию: %v", descr)
+	}
+	logsmap[LOGINFO_WITHSTD].Printf("сверка: исходных чеков %v, исправлено %v, не исправлено %v, исправлено частично %v, исправлено повторно %v, лишних чеков коррекции %v. Результат в файле %v%v",
+		len(originals), countsOfStatus[RECONCILECORRECTED], countsOfStatus[RECONCILEMISSING], countsOfStatus[RECONCILEPARTIAL],
+		countsOfStatus[RECONCILEDUPLICATE], len(unknown), JSONRES, FILERECONCILE)
+}
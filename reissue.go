@@ -0,0 +1,64 @@
+package main
+
+//режим -reissue: для каждого чека формируются два задания - обратная коррекция исходного чека
+//в том виде, в котором он был зарегистрирован, и прямая коррекция с исправленными данными
+import (
+	"fmt"
+	"strconv"
+)
+
+// псевдополя шапки чека для задания обратной коррекции
+const REVERSEOPERFIELD = "reverseoper"   //"1" - операция, обратная операции чека (вместе с флагом -reverse дает прямую операцию)
+const ASREGISTEREDFIELD = "asregistered" //"1" - чек в том виде, в котором был зарегистрирован: без переопределения СНО, принудительных СНО и ставок НДС, расчётных ставок для предоплаты и отраслевых реквизитов по умолчанию
+
+// суффиксы имен файлов пары заданий, задающие порядок их печати
+const REISSUEREVERSESUFFIX = "1_reverse"
+const REISSUEFIXEDSUFFIX = "2_fixed"
+
+func copyHeadOfCheck(headofcheck map[string]string) map[string]string {
+	res := make(map[string]string, len(headofcheck))
+	for k, v := range headofcheck {
+		res[k] = v
+	}
+	return res
+}
+
+func copyPositionsOfCheck(poss map[int]map[string]string) map[int]map[string]string {
+	res := make(map[int]map[string]string, len(poss))
+	for numPos, pos := range poss {
+		res[numPos] = copyHeadOfCheck(pos)
+	}
+	return res
+}
+
+func copyPayments(payments map[string]float64) map[string]float64 {
+	res := make(map[string]float64, len(payments))
+	for k, v := range payments {
+		res[k] = v
+	}
+	return res
+}
+
+// prepareReverseOfCheck - подготовка шапки исходного чека к формированию задания обратной коррекции.
+// Суммы оплат берутся исходные, если они соответствуют позициям исходного чека, иначе - исправленные
+func prepareReverseOfCheck(originalHead map[string]string, originalPoss map[int]map[string]string, originalPayments, fixedPayments map[string]float64, checkDescrInfo string) {
+	payments := copyPayments(originalPayments)
+	for k, v := range getPaymentsOfHead(originalHead) {
+		if _, ok := payments[k]; !ok {
+			payments[k] = v
+		}
+	}
+	if existPaymentsInTemplate() {
+		amountOfOriginal := getAmountOfPositions(originalPoss, checkDescrInfo)
+		if mistakes, descrMistakes := checkMistakeInPayments(amountOfOriginal, payments, originalPoss); mistakes {
+			logsmap[LOGREPORT].Printf("чек %v: в обратной коррекции использованы исправленные суммы оплат (%v), так как исходные ошибочны: %v", checkDescrInfo, descrOfPayments(fixedPayments), descrMistakes)
+			payments = fixedPayments
+		}
+	}
+	for k, v := range payments {
+		originalHead[k] = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	originalHead[REVERSEOPERFIELD] = "1"
+	originalHead[ASREGISTEREDFIELD] = "1"
+	logginInFile(fmt.Sprintf("для чека %v формируется пара заданий: обратная коррекция и прямая коррекция с исправлениями", checkDescrInfo))
+}
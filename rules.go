@@ -0,0 +1,235 @@
+package main
+
+//правила преобразования чеков из раздела [[rules]] файла init.toml
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const FORCEDSNOFIELD = "forcedsno"
+const FORCEDNDSFIELD = "forcednds"
+
+// условия правила, не являющиеся полями чека
+const RULEDATEFROM = "datefrom"
+const RULEDATETO = "dateto"
+const RULENDS = "nds"
+const RULESTAVKANDS5OFHEAD = "head$stavkaNDS5" //сумма НДС 5% из строки шапки чека (для правила флага -propsukatbycondition)
+
+type TRule struct {
+	Descr     string
+	When      map[string]*regexp.Regexp
+	DateFrom  string
+	DateTo    string
+	SkipCheck bool
+	SkipPos   bool
+	NDS       string
+	SNO       string
+	Set       map[string]string
+}
+
+var RulesOfChecks []TRule
+
+// initRules - чтение правил из init.toml и добавление правил, заданных флагами запуска
+func initRules(data map[string]interface{}) error {
+	RulesOfChecks = nil
+	rulesinit, _ := data["rules"].([]map[string]interface{})
+	for i, ruleinit := range rulesinit {
+		rule := TRule{When: make(map[string]*regexp.Regexp), Set: make(map[string]string)}
+		rule.Descr = fmt.Sprint(ruleinit["descr"])
+		if ruleinit["descr"] == nil {
+			rule.Descr = fmt.Sprintf("правило №%v", i+1)
+		}
+		if when, ok := ruleinit["when"].(map[string]interface{}); ok {
+			for field, val := range when {
+				valStr := fmt.Sprint(val)
+				switch field {
+				case RULEDATEFROM, RULEDATETO:
+					//дата сравнивается строкой с датой чека ГГГГ.ММ.ДД, поэтому приводится к этому формату
+					date, err := getDateOfBasis(val)
+					if err != nil {
+						return fmt.Errorf("ошибка (%v) в условии %v правила \"%v\"", err, field, rule.Descr)
+					}
+					if field == RULEDATEFROM {
+						rule.DateFrom = date
+					} else {
+						rule.DateTo = date
+					}
+				default:
+					re, err := regexp.Compile("(?i)" + valStr)
+					if err != nil {
+						return fmt.Errorf("ошибка (%v) в условии %v = \"%v\" правила \"%v\"", err, field, valStr, rule.Descr)
+					}
+					rule.When[field] = re
+				}
+			}
+		}
+		if then, ok := ruleinit["then"].(map[string]interface{}); ok {
+			rule.SkipCheck, _ = then["skipcheck"].(bool)
+			rule.SkipPos, _ = then["skippos"].(bool)
+			if nds, ok := then["nds"]; ok {
+				rule.NDS = getStavkaNDSFromStr(fmt.Sprint(nds))
+				if rule.NDS == "" {
+					return fmt.Errorf("не удалось определить ставку НДС \"%v\" правила \"%v\"", nds, rule.Descr)
+				}
+			}
+			if sno, ok := then["sno"]; ok {
+				rule.SNO = getOsnFromChernovVal(fmt.Sprint(sno))
+				if rule.SNO == "" {
+					return fmt.Errorf("не удалось определить систему налогообложения \"%v\" правила \"%v\"", sno, rule.Descr)
+				}
+			}
+			if set, ok := then["set"].(map[string]interface{}); ok {
+				for field, val := range set {
+					rule.Set[field] = fmt.Sprint(val)
+				}
+			}
+		}
+		RulesOfChecks = append(RulesOfChecks, rule)
+	}
+	//правила, которые раньше были прописаны в коде и включаются флагами запуска
+	if _, ok := FieldsNums[COLSTAVKANDS5]; ok && *propsukatByCondition {
+		//как и раньше, проверяется значение строки шапки чека, а не позиций: пустое или "0"
+		rule := TRule{Descr: "флаг -propsukatbycondition: пропуск чеков без суммы НДС 5%", SkipCheck: true}
+		rule.When = map[string]*regexp.Regexp{RULESTAVKANDS5OFHEAD: regexp.MustCompile("^0?$")}
+		RulesOfChecks = append(RulesOfChecks, rule)
+	}
+	if *changeNDSCustom {
+		RulesOfChecks = append(RulesOfChecks, TRule{Descr: "флаг -changendscustom", NDS: STAVKANDSNONE})
+	}
+	if *changeSNOCustom {
+		RulesOfChecks = append(RulesOfChecks, TRule{Descr: "флаг -changensnocustom", SNO: getOsnFromChernovVal(SnoCustom)})
+	}
+	logginInFile(fmt.Sprintf("прочитано %v правил преобразования чеков", len(RulesOfChecks)))
+	return nil
+}
+
+// isPosFieldOfRule - относится ли поле условия или действия правила к позиции чека
+func isPosFieldOfRule(field string) bool {
+	return (field == RULENDS) || slices.Contains(AllFieldPositionsOfCheck, field)
+}
+
+// isPosRule - правило проверяется для каждой позиции чека
+func (rule TRule) isPosRule() bool {
+	if rule.SkipPos || rule.NDS != "" {
+		return true
+	}
+	for field := range rule.When {
+		if isPosFieldOfRule(field) {
+			return true
+		}
+	}
+	for field := range rule.Set {
+		if isPosFieldOfRule(field) {
+			return true
+		}
+	}
+	return false
+}
+
+// getValOfFieldForRule - значение поля чека для проверки условия правила
+func getValOfFieldForRule(headofcheck, pos map[string]string, field string) []string {
+	if field == RULENDS {
+		return []string{getDeclaredStavkaNDS(pos)}
+	}
+	val, ok := pos[field]
+	if !ok {
+		val, ok = headofcheck[field]
+	}
+	if !ok {
+		val = headofcheck["inv$"+field]
+	}
+	//предмет и способ расчета сравниваются и в исходном виде, и в виде для драйвера
+	switch field {
+	case COLPREDMET:
+		return []string{val, getPredmRasch(val)}
+	case COLSPOSOB:
+		return []string{val, getSposobRash(val)}
+	}
+	return []string{val}
+}
+
+// matches - выполняются ли условия правила для шапки чека и позиции (pos может быть nil)
+func (rule TRule) matches(headofcheck, pos map[string]string) bool {
+	dateOfCheck := getDateOfCheck(headofcheck)
+	if rule.DateFrom != "" && dateOfCheck < rule.DateFrom {
+		return false
+	}
+	if rule.DateTo != "" && dateOfCheck > rule.DateTo {
+		return false
+	}
+	for field, re := range rule.When {
+		if pos == nil && isPosFieldOfRule(field) {
+			return false
+		}
+		matched := false
+		for _, val := range getValOfFieldForRule(headofcheck, pos, field) {
+			if re.MatchString(strings.TrimSpace(val)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// applyRules - применение правил к чеку. Возвращает true, если чек нужно пропустить.
+// Каждое применение правила записывается в отчёт
+func applyRules(headofcheck map[string]string, poss map[int]map[string]string, checkDescrInfo string) bool {
+	for _, rule := range RulesOfChecks {
+		if !rule.isPosRule() {
+			if !rule.matches(headofcheck, nil) {
+				continue
+			}
+			if rule.SkipCheck {
+				logsmap[LOGREPORT].Printf("чек %v пропущен по правилу \"%v\"", checkDescrInfo, rule.Descr)
+				return true
+			}
+			if rule.SNO != "" {
+				headofcheck[FORCEDSNOFIELD] = rule.SNO
+				logsmap[LOGREPORT].Printf("чек %v: по правилу \"%v\" установлена система налогообложения %v", checkDescrInfo, rule.Descr, rule.SNO)
+			}
+			for field, val := range rule.Set {
+				logsmap[LOGREPORT].Printf("чек %v: по правилу \"%v\" поле %v изменено с \"%v\" на \"%v\"", checkDescrInfo, rule.Descr, field, headofcheck[field], val)
+				headofcheck[field] = val
+			}
+			continue
+		}
+		for numPos, pos := range poss {
+			if !rule.matches(headofcheck, pos) {
+				continue
+			}
+			if rule.SkipCheck {
+				logsmap[LOGREPORT].Printf("чек %v пропущен по правилу \"%v\" (позиция %v \"%v\")", checkDescrInfo, rule.Descr, numPos, pos[COLNAME])
+				return true
+			}
+			if rule.SkipPos {
+				logsmap[LOGREPORT].Printf("чек %v: позиция %v \"%v\" пропущена по правилу \"%v\"", checkDescrInfo, numPos, pos[COLNAME], rule.Descr)
+				delete(poss, numPos)
+				continue
+			}
+			if rule.NDS != "" {
+				pos[FORCEDNDSFIELD] = rule.NDS
+				logsmap[LOGREPORT].Printf("чек %v: для позиции %v \"%v\" по правилу \"%v\" установлена ставка НДС %v", checkDescrInfo, numPos, pos[COLNAME], rule.Descr, rule.NDS)
+			}
+			if (rule.SNO != "") && (headofcheck[FORCEDSNOFIELD] != rule.SNO) {
+				headofcheck[FORCEDSNOFIELD] = rule.SNO
+				logsmap[LOGREPORT].Printf("чек %v: по правилу \"%v\" установлена система налогообложения %v", checkDescrInfo, rule.Descr, rule.SNO)
+			}
+			for field, val := range rule.Set {
+				if isPosFieldOfRule(field) {
+					logsmap[LOGREPORT].Printf("чек %v: для позиции %v \"%v\" по правилу \"%v\" поле %v изменено с \"%v\" на \"%v\"", checkDescrInfo, numPos, pos[COLNAME], rule.Descr, field, pos[field], val)
+					pos[field] = val
+				} else {
+					logsmap[LOGREPORT].Printf("чек %v: по правилу \"%v\" поле %v изменено с \"%v\" на \"%v\"", checkDescrInfo, rule.Descr, field, headofcheck[field], val)
+					headofcheck[field] = val
+				}
+			}
+		}
+	}
+	return false
+}
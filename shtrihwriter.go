@@ -0,0 +1,309 @@
+package main
+
+//writer xml заданий драйвера Штрих-М (DrvFR). ЭКСПЕРИМЕНТАЛЬНЫЙ формат: у драйвера нет документированного
+//xml задания чека, поэтому разметка (CorrectionReceipt, FNOperation, FNSendTag, FNCloseCheckEx, TaxValue) - собственное
+//описание последовательности вызовов драйвера для чека коррекции ФФД 1.2: открытие чека коррекции, FNOperation
+//по каждой позиции, FNSendTag для реквизитов чека и FNCloseCheckEx с суммами оплат. Для печати задание нужно
+//преобразовать в вызовы драйвера своей обработкой. Значения перечислений - коды тегов ФФД
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+type TShtrihWriter struct{}
+
+type TShtrihTask struct {
+	XMLName        xml.Name            `xml:"CorrectionReceipt"`
+	CheckType      int                 `xml:"CheckType,attr"`      //тег 1054: 1 - приход, 2 - возврат прихода, 3 - расход, 4 - возврат расхода
+	CorrectionType int                 `xml:"CorrectionType,attr"` //тег 1173: 0 - самостоятельно, 1 - по предписанию
+	Electronically bool                `xml:"Electronically,attr"`
+	CorrectionBase TShtrihCorrBase     `xml:"CorrectionBase"`
+	Customer       *TShtrihCustomer    `xml:"Customer,omitempty"`
+	Operator       TShtrihOperator     `xml:"Operator"`
+	Tags           []TShtrihTag        `xml:"FNSendTag"`
+	Operations     []TShtrihOperation  `xml:"FNOperation"`
+	CloseCheck     TShtrihCloseCheckEx `xml:"FNCloseCheckEx"`
+}
+
+// тег 1174: дата (1178) и номер (1179) документа основания
+type TShtrihCorrBase struct {
+	Date   string `xml:"Date,attr"` //ДД.ММ.ГГГГ
+	Number string `xml:"Number,attr"`
+}
+
+type TShtrihCustomer struct {
+	Contact      string `xml:"Contact,attr,omitempty"`      //тег 1008
+	INN          string `xml:"INN,attr,omitempty"`          //тег 1228
+	Name         string `xml:"Name,attr,omitempty"`         //тег 1227
+	BirthDate    string `xml:"BirthDate,attr,omitempty"`    //тег 1243
+	Citizenship  string `xml:"Citizenship,attr,omitempty"`  //тег 1244
+	DocumentCode string `xml:"DocumentCode,attr,omitempty"` //тег 1245
+	DocumentData string `xml:"DocumentData,attr,omitempty"` //тег 1246
+	Address      string `xml:"Address,attr,omitempty"`      //тег 1254
+}
+
+type TShtrihOperator struct {
+	Name string `xml:"Name,attr"`          //тег 1021
+	INN  string `xml:"INN,attr,omitempty"` //тег 1203
+}
+
+type TShtrihTag struct {
+	Tag   int          `xml:"Tag,attr"`
+	Value string       `xml:"Value,attr,omitempty"`
+	Tags  []TShtrihTag `xml:"FNSendTag,omitempty"` //вложенные теги составного реквизита
+}
+
+type TShtrihOperation struct {
+	StringForPrinting string             `xml:"StringForPrinting,attr"`
+	Price             string             `xml:"Price,attr"`
+	Quantity          string             `xml:"Quantity,attr"`
+	Summ1             string             `xml:"Summ1,attr"`           //стоимость позиции
+	Tax1              int                `xml:"Tax1,attr"`            //ставка НДС (тег 1199)
+	PaymentTypeSign   int                `xml:"PaymentTypeSign,attr"` //способ расчёта (тег 1214)
+	PaymentItemSign   int                `xml:"PaymentItemSign,attr"` //предмет расчёта (тег 1212)
+	MeasureUnit       int                `xml:"MeasureUnit,attr"`     //мера количества (тег 2108)
+	Barcode           string             `xml:"FNSendItemBarcode,omitempty"`
+	ProductCode       *TShtrihProdCode   `xml:"ProductCode,omitempty"`
+	Agent             *TShtrihAgentOfPos `xml:"Agent,omitempty"`
+	Tags              []TShtrihTag       `xml:"FNSendTagOperation,omitempty"` //реквизиты позиции (тег 1260)
+}
+
+// код товара без проверки КМ (теги 1162/1163)
+type TShtrihProdCode struct {
+	Type string `xml:"Type,attr"`
+	Code string `xml:",chardata"`
+}
+
+type TShtrihAgentOfPos struct {
+	AgentSign                     int      `xml:"AgentSign,attr"`                         //признак агента (тег 1222), битовая маска
+	SupplierINN                   string   `xml:"SupplierINN,attr"`                       //тег 1226
+	SupplierName                  string   `xml:"SupplierName,attr,omitempty"`            //тег 1225
+	PayingAgentOperation          string   `xml:"PayingAgentOperation,attr,omitempty"`    //тег 1044
+	TransferOperatorName          string   `xml:"TransferOperatorName,attr,omitempty"`    //тег 1026
+	TransferOperatorAddress       string   `xml:"TransferOperatorAddress,attr,omitempty"` //тег 1005
+	TransferOperatorINN           string   `xml:"TransferOperatorINN,attr,omitempty"`     //тег 1016
+	SupplierPhones                []string `xml:"SupplierPhone,omitempty"`                //тег 1171
+	PayingAgentPhones             []string `xml:"PayingAgentPhone,omitempty"`             //тег 1073
+	ReceivePaymentsOperatorPhones []string `xml:"ReceivePaymentsOperatorPhone,omitempty"` //тег 1074
+	TransferOperatorPhones        []string `xml:"TransferOperatorPhone,omitempty"`        //тег 1075
+}
+
+type TShtrihCloseCheckEx struct {
+	TaxType int               `xml:"TaxType,attr"` //СНО (тег 1055), битовая маска
+	Summ1   string            `xml:"Summ1,attr"`   //наличными
+	Summ2   string            `xml:"Summ2,attr"`   //безналичными
+	Summ14  string            `xml:"Summ14,attr"`  //зачёт аванса
+	Summ15  string            `xml:"Summ15,attr"`  //в кредит
+	Summ16  string            `xml:"Summ16,attr"`  //встречным представлением
+	Taxes   []TShtrihTaxValue `xml:"TaxValue"`
+}
+
+// сумма НДС чека по ставке
+type TShtrihTaxValue struct {
+	Tax int    `xml:"Tax,attr"` //ставка в нумерации Tax1
+	Sum string `xml:"Sum,attr"`
+}
+
+var shtrihCheckTypes = map[string]int{
+	"sellCorrection": 1, "sellReturnCorrection": 2, "buyCorrection": 3, "buyReturnCorrection": 4,
+}
+
+var shtrihTaxTypes = map[string]int{
+	"osn": 1, "usnIncome": 2, "usnIncomeOutcome": 4, "envd": 8, "esn": 16, "patent": 32,
+}
+
+// нумерация ставок свойства Tax1 драйвера
+var shtrihTaxes = map[string]int{
+	STAVKANDS20: 1, STAVKANDS10: 2, STAVKANDS0: 3, STAVKANDSNONE: 4, STAVKANDS120: 5, STAVKANDS110: 6,
+	STAVKANDS5: 7, STAVKANDS7: 8, STAVKANDS105: 9, STAVKANDS107: 10,
+}
+
+var shtrihPaymentMethods = map[string]int{
+	"fullPrepayment": 1, "prepayment": 2, "advance": 3, "fullPayment": 4, "partialPayment": 5, "credit": 6, "creditPayment": 7,
+}
+
+var shtrihPaymentObjects = map[string]int{
+	"commodity": 1, "excise": 2, "job": 3, "service": 4, "gamblingBet": 5, "gamblingPrize": 6, "lottery": 7,
+	"lotteryPrize": 8, "intellectualActivity": 9, "payment": 10, "agentCommission": 11, "award": 12, "another": 13,
+	"proprietaryLaw": 14, "nonOperatingIncome": 15, "otherContributions": 16, "merchantTax": 17, "resortFee": 18,
+	"deposit": 19, "consumption": 20, "soleProprietorCPIContributions": 21, "cpiContributions": 22,
+	"soleProprietorCMIContributions": 23, "cmiContributions": 24, "csiContributions": 25, "casinoPayment": 26,
+	"fundsIssuance": 27, "exciseWithoutMarking": 30, "exciseWithMarking": 31, "commodityWithoutMarking": 32,
+	"commodityWithMarking": 33,
+}
+
+var shtrihAgents = map[string]int{
+	"bankPayingAgent": 1, "bankPayingSubagent": 2, "payingAgent": 4, "payingSubagent": 8,
+	"attorney": 16, "commissionAgent": 32, "another": 64,
+}
+
+func (TShtrihWriter) FileExt() string {
+	return "xml"
+}
+
+func (TShtrihWriter) Marshal(checkCorr TCorrection) ([]byte, error) {
+	task, err := getShtrihTaskOfCorrection(checkCorr)
+	if err != nil {
+		return nil, err
+	}
+	res, err := xml.MarshalIndent(task, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), res...), nil
+}
+
+func formatShtrihSum(sum float64) string {
+	return strconv.FormatFloat(sum, 'f', -1, 64)
+}
+
+// getShtrihCode - код значения перечисления. Значение, которого нет в справочнике, делает задание невозможным
+func getShtrihCode(codes map[string]int, val, descr string) (int, error) {
+	code, ok := codes[val]
+	if !ok {
+		return 0, fmt.Errorf("значение \"%v\" (%v) не поддерживается форматом %v", val, descr, OUTFORMATSHTRIH)
+	}
+	return code, nil
+}
+
+// getCodeOfMeasUnit - код тега 2108 для единицы измерения по справочнику единиц
+func getCodeOfMeasUnit(unit string) (int, error) {
+	for k, v := range measUnitsDict {
+		if v != unit {
+			continue
+		}
+		if code, err := strconv.Atoi(k); err == nil {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("значение \"%v\" (единица измерения) не поддерживается форматом %v", unit, OUTFORMATSHTRIH)
+}
+
+// getShtrihTaskOfCorrection - задание чека коррекции драйвера Штрих-М по модели чека
+func getShtrihTaskOfCorrection(checkCorr TCorrection) (TShtrihTask, error) {
+	var task TShtrihTask
+	var err error
+	if task.CheckType, err = getShtrihCode(shtrihCheckTypes, checkCorr.Type, "тип чека коррекции"); err != nil {
+		return task, err
+	}
+	if checkCorr.CorrectionType == CORRECTIONTYPEINSTRUCTION {
+		task.CorrectionType = 1
+	}
+	task.Electronically = checkCorr.Electronically
+	task.CorrectionBase.Date = checkCorr.CorrectionBaseDate
+	if dateOfBase, errDate := time.Parse("2006.01.02", checkCorr.CorrectionBaseDate); errDate == nil {
+		task.CorrectionBase.Date = dateOfBase.Format("02.01.2006")
+	}
+	task.CorrectionBase.Number = checkCorr.CorrectionBaseNumber
+	if client := checkCorr.ClientInfo; !isEmptyClientInfo(client) {
+		task.Customer = &TShtrihCustomer{Contact: client.EmailOrPhone, INN: client.Vatin, Name: client.Name, BirthDate: client.BirthDate,
+			Citizenship: client.Citizenship, DocumentCode: client.IdentityDocumentCode, DocumentData: client.IdentityDocumentData, Address: client.Address}
+	}
+	task.Operator = TShtrihOperator{Name: checkCorr.Operator.Name, INN: checkCorr.Operator.Vatin}
+	if checkCorr.AdditionalAttribute != "" {
+		task.Tags = append(task.Tags, TShtrihTag{Tag: 1192, Value: checkCorr.AdditionalAttribute})
+	}
+	for _, attr := range checkCorr.UserAttributes {
+		task.Tags = append(task.Tags, TShtrihTag{Tag: 1084, Tags: []TShtrihTag{{Tag: 1085, Value: attr.Name}, {Tag: 1086, Value: attr.Value}}})
+	}
+	for _, info := range checkCorr.IndustryInfo {
+		task.Tags = append(task.Tags, getShtrihTagOfIndustryInfo(1261, info))
+	}
+	for _, pos := range checkCorr.Positions {
+		oper, err := getShtrihOperationOfPosition(pos)
+		if err != nil {
+			return task, fmt.Errorf("позиция %v: %v", pos.Name, err)
+		}
+		task.Operations = append(task.Operations, oper)
+	}
+	if checkCorr.TaxationType != "" {
+		if task.CloseCheck.TaxType, err = getShtrihCode(shtrihTaxTypes, checkCorr.TaxationType, "система налогообложения"); err != nil {
+			return task, err
+		}
+	}
+	summs := make(map[string]float64)
+	for _, pay := range checkCorr.Payments {
+		summs[pay.Type] += pay.Sum
+	}
+	task.CloseCheck.Summ1 = formatShtrihSum(summs["cash"])
+	task.CloseCheck.Summ2 = formatShtrihSum(summs["electronically"])
+	task.CloseCheck.Summ14 = formatShtrihSum(summs["prepaid"])
+	task.CloseCheck.Summ15 = formatShtrihSum(summs["credit"])
+	task.CloseCheck.Summ16 = formatShtrihSum(summs["other"])
+	for _, tax := range checkCorr.Taxes {
+		taxOfDrv, err := getShtrihCode(shtrihTaxes, tax.Type, "ставка НДС")
+		if err != nil {
+			return task, err
+		}
+		task.CloseCheck.Taxes = append(task.CloseCheck.Taxes, TShtrihTaxValue{Tax: taxOfDrv, Sum: formatShtrihSum(tax.Sum)})
+	}
+	return task, nil
+}
+
+func getShtrihOperationOfPosition(pos TCorrectionPosition) (TShtrihOperation, error) {
+	var oper TShtrihOperation
+	var err error
+	oper.StringForPrinting = pos.Name
+	oper.Price = formatShtrihSum(pos.Price)
+	oper.Quantity = formatShtrihSum(pos.Quantity)
+	oper.Summ1 = formatShtrihSum(pos.Amount)
+	if oper.Tax1, err = getShtrihCode(shtrihTaxes, pos.Tax, "ставка НДС"); err != nil {
+		return oper, err
+	}
+	if oper.PaymentTypeSign, err = getShtrihCode(shtrihPaymentMethods, pos.PaymentMethod, "способ расчёта"); err != nil {
+		return oper, err
+	}
+	if oper.PaymentItemSign, err = getShtrihCode(shtrihPaymentObjects, pos.PaymentObject, "предмет расчёта"); err != nil {
+		return oper, err
+	}
+	if oper.MeasureUnit, err = getCodeOfMeasUnit(pos.MeasurementUnit); err != nil {
+		return oper, err
+	}
+	if pos.Mark != "" {
+		if isProductCodeOfMark(pos.MarkType) {
+			oper.ProductCode = &TShtrihProdCode{Type: pos.MarkType, Code: pos.Mark}
+		} else {
+			oper.Barcode = pos.Mark
+		}
+	}
+	if pos.AgentInfo != nil {
+		oper.Agent = new(TShtrihAgentOfPos)
+		for _, agent := range pos.AgentInfo.Agents {
+			sign, err := getShtrihCode(shtrihAgents, agent, "признак агента")
+			if err != nil {
+				return oper, err
+			}
+			oper.Agent.AgentSign |= sign
+		}
+		if payingAgent := pos.AgentInfo.PayingAgent; payingAgent != nil {
+			oper.Agent.PayingAgentOperation = payingAgent.Operation
+			oper.Agent.PayingAgentPhones = payingAgent.Phones
+		}
+		if receiveOper := pos.AgentInfo.ReceivePaymentsOperator; receiveOper != nil {
+			oper.Agent.ReceivePaymentsOperatorPhones = receiveOper.Phones
+		}
+		if transferOper := pos.AgentInfo.MoneyTransferOperator; transferOper != nil {
+			oper.Agent.TransferOperatorName = transferOper.Name
+			oper.Agent.TransferOperatorAddress = transferOper.Address
+			oper.Agent.TransferOperatorINN = transferOper.Vatin
+			oper.Agent.TransferOperatorPhones = transferOper.Phones
+		}
+		if pos.SupplierInfo != nil {
+			oper.Agent.SupplierINN = pos.SupplierInfo.Vatin
+			oper.Agent.SupplierName = pos.SupplierInfo.Name
+			oper.Agent.SupplierPhones = pos.SupplierInfo.Phones
+		}
+	}
+	for _, info := range pos.IndustryInfo {
+		oper.Tags = append(oper.Tags, getShtrihTagOfIndustryInfo(1260, info))
+	}
+	return oper, nil
+}
+
+// getShtrihTagOfIndustryInfo - составной отраслевой реквизит (тег 1260 или 1261)
+func getShtrihTagOfIndustryInfo(tag int, info TIndustryInfo) TShtrihTag {
+	return TShtrihTag{Tag: tag, Tags: []TShtrihTag{{Tag: 1262, Value: info.Fois}, {Tag: 1263, Value: info.Date},
+		{Tag: 1264, Value: info.Number}, {Tag: 1265, Value: info.IndustryAttribute}}}
+}
@@ -0,0 +1,385 @@
+package main
+
+//команда submit: отправка json заданий на веб-сервер драйвера атол (JSON API /api/v2/requests),
+//ожидание результата и запись фискальных данных чеков коррекции
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const COMMANDSUBMIT = "submit"
+const COMMANDATOLSTUB = "atolstub"
+
+const FILEPRINTED = "printed.txt"
+const FILECONNECTION = "connection.txt"
+const FILESUBMITRESULTS = "results.csv"
+const ATOLAPIREQUESTS = "/api/v2/requests"
+
+// статусы задания в журнале printed.txt
+const SUBMITSTATUSSENT = "sent"
+const SUBMITSTATUSREADY = "ready"
+const SUBMITSTATUSERROR = "error"
+
+// ошибка: веб-сервер не знает задание с таким uuid
+var errAtolRequestNotFound = errors.New("задание не найдено на веб-сервере атол")
+
+type TAtolRequest struct {
+	UUID    string            `json:"uuid"`
+	Request []json.RawMessage `json:"request"`
+}
+
+type TAtolFiscalParams struct {
+	FiscalDocumentNumber   int    `json:"fiscalDocumentNumber"`
+	FiscalDocumentSign     string `json:"fiscalDocumentSign"`
+	FiscalReceiptNumber    int    `json:"fiscalReceiptNumber"`
+	ShiftNumber            int    `json:"shiftNumber"`
+	FnNumber               string `json:"fnNumber"`
+	FiscalDocumentDateTime string `json:"fiscalDocumentDateTime"`
+}
+
+type TAtolResult struct {
+	Status           string `json:"status"` //wait, inProgress, ready, error, interrupted, blocked, canceled
+	ErrorCode        int    `json:"errorCode"`
+	ErrorDescription string `json:"errorDescription"`
+	Result           *struct {
+		FiscalParams *TAtolFiscalParams `json:"fiscalParams,omitempty"`
+	} `json:"result,omitempty"`
+}
+
+type TAtolResponse struct {
+	Results []TAtolResult `json:"results"`
+}
+
+// запись журнала printed.txt: файл;uuid;статус;ФД;ФП;смена;описание
+type TSubmitJournalRecord struct {
+	File        string
+	UUID        string
+	Status      string
+	FD          string
+	FP          string
+	Shift       string
+	Description string
+}
+
+var regexpFNFDOfFile = regexp.MustCompile(`(\d{16})_0*(\d+)`)
+
+var httpClientOfAtol = &http.Client{Timeout: 30 * time.Second}
+
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// getAtolServerOfFN - адрес веб-сервера атол для ФН: первая непустая строка connection.txt
+// в папке ФН, иначе значение флага -atolserver
+func getAtolServerOfFN(dirOfFN string) string {
+	res := strings.TrimRight(*atolServer, "/")
+	content, err := os.ReadFile(dirOfFN + FILECONNECTION)
+	if err != nil {
+		return res
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, "http") {
+			line = "http://" + line
+		}
+		return strings.TrimRight(line, "/")
+	}
+	return res
+}
+
+// readSubmitJournal - последняя запись журнала printed.txt по каждому файлу задания
+func readSubmitJournal(dirOfFN string) map[string]TSubmitJournalRecord {
+	res := make(map[string]TSubmitJournalRecord)
+	f, err := os.Open(dirOfFN + FILEPRINTED)
+	if err != nil {
+		return res
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), ";")
+		if len(fields) < 3 {
+			continue
+		}
+		for len(fields) < 7 {
+			fields = append(fields, "")
+		}
+		res[fields[0]] = TSubmitJournalRecord{File: fields[0], UUID: fields[1], Status: fields[2],
+			FD: fields[3], FP: fields[4], Shift: fields[5], Description: fields[6]}
+	}
+	return res
+}
+
+// addToSubmitJournal - запись в журнал сразу сбрасывается на диск, чтобы после прерывания
+// не отправить задание повторно
+func addToSubmitJournal(dirOfFN string, rec TSubmitJournalRecord) error {
+	f, err := os.OpenFile(dirOfFN+FILEPRINTED, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	descr := strings.ReplaceAll(rec.Description, ";", ",")
+	if _, err := fmt.Fprintf(f, "%v;%v;%v;%v;%v;%v;%v\n", rec.File, rec.UUID, rec.Status, rec.FD, rec.FP, rec.Shift, descr); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func addToSubmitResults(dirOfFN string, rec TSubmitJournalRecord) error {
+	fullFileName := dirOfFN + FILESUBMITRESULTS
+	existfile, _ := doesFileExist(fullFileName)
+	f, err := os.OpenFile(fullFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if !existfile {
+		fmt.Fprintln(f, "file;fn;fd;uuid;correctionfd;correctionfp;shift")
+	}
+	fnOfSource, fdOfSource := "", ""
+	if parts := regexpFNFDOfFile.FindStringSubmatch(filepath.Base(rec.File)); parts != nil {
+		fnOfSource, fdOfSource = parts[1], parts[2]
+	}
+	_, err = fmt.Fprintf(f, "%v;%v;%v;%v;%v;%v;%v\n", rec.File, fnOfSource, fdOfSource, rec.UUID, rec.FD, rec.FP, rec.Shift)
+	return err
+}
+
+// getTasksOfFN - файлы заданий ФН в порядке выполнения: по manifest.json плана печати, если он есть,
+// иначе все json файлы папки ФН по номеру ФД в имени файла (ФН_100 после ФН_99), а при одном ФД - по имени,
+// чтобы обратная коррекция (1_reverse) шла перед исправленным чеком (2_fixed)
+func getTasksOfFN(dirOfFN string) ([]string, error) {
+	var res []string
+	manifestFileName := dirOfFN + DIRPRINTPLAN + FILEMANIFEST
+	if existfile, _ := doesFileExist(manifestFileName); existfile {
+		content, err := os.ReadFile(manifestFileName)
+		if err != nil {
+			return res, err
+		}
+		var manifest TManifest
+		if err := json.Unmarshal(content, &manifest); err != nil {
+			return res, fmt.Errorf("ошибка (%v) разбора файла %v", err, manifestFileName)
+		}
+		for _, task := range manifest.Tasks {
+			res = append(res, DIRPRINTPLAN+task.File)
+		}
+		return res, nil
+	}
+	entries, err := os.ReadDir(dirOfFN)
+	if err != nil {
+		return res, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			res = append(res, entry.Name())
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		fdI, fdJ := getFDOfTaskFile(res[i]), getFDOfTaskFile(res[j])
+		if fdI != fdJ {
+			return fdI < fdJ
+		}
+		return res[i] < res[j]
+	})
+	return res, nil
+}
+
+// getFDOfTaskFile - номер ФД из имени файла задания (ФН_ФД...), 0 - если его нет
+func getFDOfTaskFile(fileName string) int {
+	parts := regexpFNFDOfFile.FindStringSubmatch(fileName)
+	if parts == nil {
+		return 0
+	}
+	fd, _ := strconv.Atoi(parts[2])
+	return fd
+}
+
+func postAtolRequest(server, uuid string, task []byte) error {
+	body, err := json.Marshal(TAtolRequest{UUID: uuid, Request: []json.RawMessage{task}})
+	if err != nil {
+		return err
+	}
+	resp, err := httpClientOfAtol.Post(server+ATOLAPIREQUESTS, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		answer, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("веб-сервер атол вернул код %v: %v", resp.StatusCode, strings.TrimSpace(string(answer)))
+	}
+	return nil
+}
+
+func getAtolResult(server, uuid string) (TAtolResult, error) {
+	var res TAtolResult
+	resp, err := httpClientOfAtol.Get(server + ATOLAPIREQUESTS + "/" + uuid)
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return res, errAtolRequestNotFound
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return res, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return res, fmt.Errorf("веб-сервер атол вернул код %v: %v", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var answer TAtolResponse
+	if err := json.Unmarshal(body, &answer); err != nil {
+		return res, fmt.Errorf("ошибка (%v) разбора ответа веб-сервера атол", err)
+	}
+	if len(answer.Results) == 0 {
+		return res, errors.New("веб-сервер атол вернул пустой результат")
+	}
+	return answer.Results[0], nil
+}
+
+// waitAtolResult - опрос веб-сервера до получения окончательного статуса задания
+func waitAtolResult(server, uuid string) (TAtolResult, error) {
+	deadline := time.Now().Add(time.Duration(*submitTimeout) * time.Second)
+	for {
+		res, err := getAtolResult(server, uuid)
+		if err != nil {
+			return res, err
+		}
+		switch res.Status {
+		case "ready", "error", "interrupted", "blocked", "canceled":
+			return res, nil
+		}
+		if time.Now().After(deadline) {
+			return res, fmt.Errorf("задание %v не выполнено за %v секунд (статус %v)", uuid, *submitTimeout, res.Status)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// submitTask - отправка одного задания с учётом журнала. Возвращает запись журнала с результатом
+func submitTask(dirOfFN, server, fileOfTask string, prev TSubmitJournalRecord, existPrev bool) (TSubmitJournalRecord, error) {
+	rec := TSubmitJournalRecord{File: fileOfTask}
+	needPost := true
+	if existPrev && prev.Status == SUBMITSTATUSSENT {
+		//задание было отправлено, но результат не получен - сначала спрашиваем веб-сервер
+		rec.UUID = prev.UUID
+		if _, err := getAtolResult(server, prev.UUID); err == nil {
+			needPost = false
+		} else if !errors.Is(err, errAtolRequestNotFound) {
+			return rec, err
+		}
+	}
+	if needPost {
+		task, err := os.ReadFile(dirOfFN + fileOfTask)
+		if err != nil {
+			return rec, err
+		}
+		if rec.UUID == "" {
+			rec.UUID = newUUID()
+		}
+		rec.Status = SUBMITSTATUSSENT
+		if err := addToSubmitJournal(dirOfFN, rec); err != nil {
+			return rec, err
+		}
+		if err := postAtolRequest(server, rec.UUID, task); err != nil {
+			return rec, err
+		}
+	}
+	result, err := waitAtolResult(server, rec.UUID)
+	if err != nil {
+		return rec, err
+	}
+	if result.Status != "ready" {
+		rec.Status = SUBMITSTATUSERROR
+		rec.Description = fmt.Sprintf("статус %v, ошибка %v: %v", result.Status, result.ErrorCode, result.ErrorDescription)
+	} else {
+		rec.Status = SUBMITSTATUSREADY
+		if result.Result != nil && result.Result.FiscalParams != nil {
+			rec.FD = fmt.Sprint(result.Result.FiscalParams.FiscalDocumentNumber)
+			rec.FP = result.Result.FiscalParams.FiscalDocumentSign
+			rec.Shift = fmt.Sprint(result.Result.FiscalParams.ShiftNumber)
+		}
+	}
+	if err := addToSubmitJournal(dirOfFN, rec); err != nil {
+		return rec, err
+	}
+	return rec, nil
+}
+
+// submitTasksOfFN - отправка всех заданий ФН по порядку. Выполненные задания пропускаются,
+// на первой ошибке отправка заданий ФН прекращается, чтобы не нарушить порядок
+func submitTasksOfFN(dirOfFN string) (int, error) {
+	server := getAtolServerOfFN(dirOfFN)
+	tasks, err := getTasksOfFN(dirOfFN)
+	if err != nil {
+		return 0, err
+	}
+	journal := readSubmitJournal(dirOfFN)
+	countOfSubmitted := 0
+	for _, fileOfTask := range tasks {
+		prev, existPrev := journal[fileOfTask]
+		if existPrev && prev.Status == SUBMITSTATUSREADY {
+			continue
+		}
+		//документы АТОЛ Онлайн отправляет команда onlinesubmit
+		if content, err := os.ReadFile(dirOfFN + fileOfTask); err == nil && isAtolOnlineTask(content) {
+			continue
+		}
+		logsmap[LOGINFO_WITHSTD].Printf("отправка задания %v%v на %v", dirOfFN, fileOfTask, server)
+		rec, err := submitTask(dirOfFN, server, fileOfTask, prev, existPrev)
+		if err != nil {
+			return countOfSubmitted, fmt.Errorf("задание %v: %v", fileOfTask, err)
+		}
+		if rec.Status == SUBMITSTATUSERROR {
+			return countOfSubmitted, fmt.Errorf("задание %v не выполнено: %v", fileOfTask, rec.Description)
+		}
+		countOfSubmitted++
+		if rec.FD != "" && regexpFNFDOfFile.MatchString(filepath.Base(fileOfTask)) {
+			if err := addToSubmitResults(dirOfFN, rec); err != nil {
+				logsmap[LOGERROR].Printf("ошибка (%v) записи результата задания %v", err, fileOfTask)
+			}
+			logsmap[LOGREPORT].Printf("задание %v%v выполнено: ФД %v, ФП %v, смена %v", dirOfFN, fileOfTask, rec.FD, rec.FP, rec.Shift)
+		}
+	}
+	return countOfSubmitted, nil
+}
+
+// runSubmit - команда submit: отправка заданий всех ФН из папки json
+func runSubmit() {
+	entries, err := os.ReadDir(JSONRES)
+	if err != nil {
+		logsmap[LOGERROR].Printf("ошибка (%v) чтения папки %v", err, JSONRES)
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dirOfFN := JSONRES + entry.Name() + "/"
+		countOfSubmitted, err := submitTasksOfFN(dirOfFN)
+		if err != nil {
+			logsmap[LOGERROR].Printf("отправка заданий ФН %v прервана: %v", entry.Name(), err)
+		}
+		logsmap[LOGINFO_WITHSTD].Printf("для ФН %v выполнено %v заданий", entry.Name(), countOfSubmitted)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestGetTasksOfFN(t *testing.T) {
+	dir := t.TempDir() + "/"
+	files := []string{"7280440500080718_100.json", "7280440500080718_99.json", "7280440500080718_0000000101_2_fixed.json",
+		"7280440500080718_0000000101_1_reverse.json", "7280440500080718_1000.json", "printed.txt"}
+	for _, file := range files {
+		if err := os.WriteFile(dir+file, []byte("{}"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := getTasksOfFN(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"7280440500080718_99.json", "7280440500080718_100.json", "7280440500080718_0000000101_1_reverse.json",
+		"7280440500080718_0000000101_2_fixed.json", "7280440500080718_1000.json"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("порядок заданий %v, ожидался %v", got, want)
+	}
+}
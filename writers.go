@@ -0,0 +1,77 @@
+package main
+
+//writer - формирование задания чека коррекции в формате драйвера ККТ по модели TCorrection.
+//Формат выбирается флагом -outformat, для отдельных ФН - таблицей [output.fn] файла init.toml
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const OUTFORMATATOL = "atol"     //json задания драйвера атол (ДТО 10)
+const OUTFORMATSHTRIH = "shtrih" //экспериментальный xml задания драйвера Штрих-М (DrvFR), разметка не документирована драйвером
+//OUTFORMATATOLONLINE - документы облачной кассы АТОЛ Онлайн (atolonlinewriter.go)
+//OUTFORMATATOL105 - json задания драйвера атол для чека коррекции ФФД 1.05 без позиций (atol105writer.go)
+
+type TCorrectionWriter interface {
+	FileExt() string //расширение файла задания без точки
+	Marshal(checkCorr TCorrection) ([]byte, error)
+}
+
+var CorrectionWriters = map[string]TCorrectionWriter{
+	OUTFORMATATOL:       TAtolWriter{},
+	OUTFORMATSHTRIH:     TShtrihWriter{},
+	OUTFORMATATOLONLINE: TAtolOnlineWriter{},
+	OUTFORMATATOL105:    TAtol105Writer{},
+}
+
+// формат заданий для отдельных ФН (раздел [output.fn] файла init.toml)
+var OutFormatsFN map[string]string
+
+func getNamesOfOutFormats() string {
+	var names []string
+	for name := range CorrectionWriters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// initOutFormats - проверка флага -outformat и чтение таблицы форматов заданий по номеру ФН
+func initOutFormats(data map[string]interface{}) error {
+	if _, ok := CorrectionWriters[*outFormat]; !ok {
+		return fmt.Errorf("неизвестный формат заданий -outformat %v (допустимы %v)", *outFormat, getNamesOfOutFormats())
+	}
+	OutFormatsFN = make(map[string]string)
+	initAtolOnline(data)
+	usedFormats := map[string]bool{*outFormat: true}
+	outputinit, _ := data["output"].(map[string]interface{})
+	if outputfn, ok := outputinit["fn"].(map[string]interface{}); ok {
+		for k, v := range outputfn {
+			format := strings.ToLower(strings.TrimSpace(fmt.Sprint(v)))
+			if _, ok := CorrectionWriters[format]; !ok {
+				return fmt.Errorf("неизвестный формат заданий %v для ФН %v в разделе [output.fn] (допустимы %v)", v, k, getNamesOfOutFormats())
+			}
+			OutFormatsFN[strings.TrimLeft(k, "0")] = format
+			usedFormats[format] = true
+		}
+	}
+	if usedFormats[OUTFORMATSHTRIH] {
+		logsmap[LOGINFO_WITHSTD].Printf("формат заданий %v экспериментальный: разметка xml не является документированным форматом драйвера Штрих-М, задания нужно проверить перед печатью", OUTFORMATSHTRIH)
+	}
+	if usedFormats[OUTFORMATATOLONLINE] {
+		if err := checkAtolOnlineForDocuments(); err != nil {
+			return err
+		}
+	}
+	logginInFile(fmt.Sprintf("OutFormatsFN=%v", OutFormatsFN))
+	return nil
+}
+
+// getOutFormatOfFN - формат заданий для ФН: значение из [output.fn] имеет приоритет над флагом -outformat
+func getOutFormatOfFN(fn string) string {
+	if format, ok := OutFormatsFN[strings.TrimLeft(strings.TrimSpace(fn), "0")]; ok {
+		return format
+	}
+	return *outFormat
+}